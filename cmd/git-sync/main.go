@@ -41,10 +41,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/git-sync/pkg/pid1"
+	"k8s.io/git-sync/pkg/process"
 	"k8s.io/git-sync/pkg/version"
 )
 
 var flVer = flag.Bool("version", false, "print the version and exit")
+var flListProcs = flag.Bool("list-procs", false, "print the PID and name of every visible process and exit")
 
 var flRepo = flag.String("repo", envString("GIT_SYNC_REPO", ""),
 	"the git repository to clone")
@@ -81,6 +83,11 @@ var flWebhookTimeout = flag.Duration("webhook-timeout", envDuration("GIT_SYNC_WE
 var flWebhookBackoff = flag.Duration("webhook-backoff", envDuration("GIT_SYNC_WEBHOOK_BACKOFF", time.Second*3),
 	"the time to wait before retrying a failed webhook")
 
+var flProcName = flag.String("proc-name", envString("GIT_SYNC_PROC_NAME", ""),
+	"the name of processes to signal when syncs complete (default is no signal)")
+var flProcSignal = flag.String("proc-signal", envString("GIT_SYNC_PROC_SIGNAL", "SIGHUP"),
+	"the signal (name or number) to send to --proc-name processes")
+
 var flUsername = flag.String("username", envString("GIT_SYNC_USERNAME", ""),
 	"the username to use for git auth")
 var flPassword = flag.String("password", envString("GIT_SYNC_PASSWORD", ""),
@@ -127,6 +134,11 @@ var (
 		Name: "git_sync_count_total",
 		Help: "How many git syncs completed, partitioned by success",
 	}, []string{"status"})
+
+	procScanSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "git_sync_proc_scan_skipped_total",
+		Help: "How many processes were skipped while scanning /proc because they exited",
+	})
 )
 
 // initTimeout is a timeout for initialization, like git credentials setup.
@@ -135,6 +147,8 @@ const initTimeout = time.Second * 30
 func init() {
 	prometheus.MustRegister(syncDuration)
 	prometheus.MustRegister(syncCount)
+	prometheus.MustRegister(procScanSkipped)
+	process.SetScanSkippedHook(procScanSkipped.Inc)
 }
 
 func envString(key, def string) string {
@@ -216,6 +230,18 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *flListProcs {
+		procs, err := process.ListProcesses(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: can't list processes: %v\n", err)
+			os.Exit(1)
+		}
+		for _, p := range procs {
+			fmt.Printf("%d\t%s\n", p.PID, p.Name)
+		}
+		os.Exit(0)
+	}
+
 	if *flRepo == "" {
 		fmt.Fprintf(os.Stderr, "ERROR: --repo must be provided\n")
 		flag.Usage()
@@ -233,6 +259,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	procSignal, err := process.ParseSignal(*flProcSignal)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid --proc-signal: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	if _, err := exec.LookPath(*flGitCmd); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: git executable %q not found: %v\n", *flGitCmd, err)
 		os.Exit(1)
@@ -334,6 +367,7 @@ func main() {
 		go webhook.run()
 	}
 
+	signaler := &process.ChangeSignaler{Name: *flProcName, Signal: procSignal}
 	initialSync := true
 	failCount := 0
 	for {
@@ -354,8 +388,20 @@ func main() {
 			cancel()
 			time.Sleep(waitTime(*flWait))
 			continue
-		} else if changed && webhook != nil {
-			webhook.Send(hash)
+		} else {
+			if changed && webhook != nil {
+				webhook.Send(hash)
+			}
+			// A checkout which was already there when we started is what
+			// the processes have been serving, so it isn't news to them.
+			if initialSync && !changed {
+				signaler.Seed(hash)
+			}
+			// Even if nothing changed, a previous failure to signal is
+			// retried; the signaler skips hashes it has already signaled.
+			if *flProcName != "" {
+				signalProcs(signaler, hash)
+			}
 		}
 		syncDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
 		syncCount.WithLabelValues("success").Inc()
@@ -381,6 +427,22 @@ func main() {
 	}
 }
 
+// signalProcs tells processes sharing our PID namespace that the repo has
+// changed to hash, unless they have already been told about it.  Failures are
+// logged but do not fail the sync.
+func signalProcs(s *process.ChangeSignaler, hash string) {
+	n, sent, err := s.SendIfChanged(hash)
+	if err != nil {
+		log.Error(err, "failed to signal processes", "name", s.Name, "signal", s.Signal.String())
+		return
+	}
+	if !sent {
+		log.V(1).Info("processes already signaled for hash", "name", s.Name, "hash", hash)
+		return
+	}
+	log.V(0).Info("signaled processes", "name", s.Name, "signal", s.Signal.String(), "count", n, "hash", hash)
+}
+
 func waitTime(seconds float64) time.Duration {
 	return time.Duration(int(seconds*1000)) * time.Millisecond
 }
@@ -635,8 +697,9 @@ func revIsHash(ctx context.Context, rev, gitRoot string) (bool, error) {
 	return strings.HasPrefix(output, rev), nil
 }
 
-// syncRepo syncs the branch of a given repository to the destination at the given rev.
-// returns (1) whether a change occured, (2) the new hash, and (3) an error if one happened
+// syncRepo syncs the branch of a given repository to the destination at the
+// given rev.  It returns whether a new hash was checked out, which is also
+// the case for the first clone, and the hash which is checked out either way.
 func syncRepo(ctx context.Context, repo, branch, rev string, depth int, gitRoot, dest string, authUrl string) (bool, string, error) {
 	if authUrl != "" {
 		// For ASKPASS Callback URL, the credentials behind is dynamic, it needs to be
@@ -671,7 +734,7 @@ func syncRepo(ctx context.Context, repo, branch, rev string, depth int, gitRoot,
 		}
 		if local == remote {
 			log.V(1).Info("no update required", "rev", rev, "local", local, "remote", remote)
-			return false, "", nil
+			return false, local, nil
 		}
 		log.V(0).Info("update required", "rev", rev, "local", local, "remote", remote)
 		hash = remote
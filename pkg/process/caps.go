@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// capabilityNames maps each capability's bit number to its name, as in
+// linux/capability.h.
+var capabilityNames = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// CapabilitySet is a set of capabilities, one bit per capability.
+type CapabilitySet uint64
+
+// Names returns the names of the capabilities in the set, e.g. "CAP_KILL",
+// in bit order.  Capabilities newer than this package are named by number,
+// e.g. "CAP_41".
+func (c CapabilitySet) Names() []string {
+	var names []string
+	for bit := uint(0); bit < 64; bit++ {
+		if c&(1<<bit) == 0 {
+			continue
+		}
+		if int(bit) < len(capabilityNames) {
+			names = append(names, capabilityNames[bit])
+		} else {
+			names = append(names, fmt.Sprintf("CAP_%d", bit))
+		}
+	}
+	return names
+}
+
+// Capabilities holds the capability sets of a process.
+type Capabilities struct {
+	Effective   CapabilitySet
+	Permitted   CapabilitySet
+	Inheritable CapabilitySet
+	// Bounding is empty on kernels older than 2.6.26, which don't report
+	// it.
+	Bounding CapabilitySet
+	// Ambient is empty on kernels older than 4.3, which don't report it.
+	Ambient CapabilitySet
+}
+
+// Capabilities returns the capability sets of the process, from the Cap*
+// fields of its status.
+func (p *Process) Capabilities() (*Capabilities, error) {
+	fields, err := p.StatusMap()
+	if err != nil {
+		return nil, err
+	}
+	caps := &Capabilities{}
+	for _, c := range []struct {
+		field    string
+		dest     *CapabilitySet
+		optional bool
+	}{
+		{"CapEff", &caps.Effective, false},
+		{"CapPrm", &caps.Permitted, false},
+		{"CapInh", &caps.Inheritable, false},
+		{"CapBnd", &caps.Bounding, true},
+		{"CapAmb", &caps.Ambient, true},
+	} {
+		val, ok := fields[c.field]
+		if !ok {
+			if c.optional {
+				continue
+			}
+			return nil, p.errorf("read status", "no %s field", c.field)
+		}
+		set, err := strconv.ParseUint(val, 16, 64)
+		if err != nil {
+			return nil, p.errorf("read status", "malformed %s %q: %v", c.field, val, err)
+		}
+		*c.dest = CapabilitySet(set)
+	}
+	return caps, nil
+}
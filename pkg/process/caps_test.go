@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// Docker's default capabilities.
+	f.write("1/status", "Name:\tapp\nCapInh:\t0000000000000000\nCapPrm:\t00000000a80425fb\nCapEff:\t00000000a80425fb\nCapBnd:\t00000000a80425fb\nCapAmb:\t0000000000000000\n")
+	// An old kernel, without CapBnd or CapAmb.
+	f.write("2/status", "Name:\told\nCapInh:\t0000000000000000\nCapPrm:\t0000000000000020\nCapEff:\t0000000000000020\n")
+	f.write("3/status", "Name:\tbroken\nCapInh:\t0\nCapPrm:\t0\n")
+	f.write("4/status", "Name:\tbroken\nCapInh:\t0\nCapPrm:\t0\nCapEff:\tzz\n")
+
+	caps, err := (&Process{Pid: 1}).Capabilities()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []string{
+		"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_FSETID",
+		"CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+		"CAP_NET_BIND_SERVICE", "CAP_NET_RAW", "CAP_SYS_CHROOT", "CAP_MKNOD",
+		"CAP_AUDIT_WRITE", "CAP_SETFCAP",
+	}
+	if names := caps.Effective.Names(); !reflect.DeepEqual(names, exp) {
+		t.Errorf("expected %v, got %v", exp, names)
+	}
+	if caps.Permitted != caps.Effective || caps.Bounding != caps.Effective {
+		t.Errorf("expected permitted and bounding sets to match effective, got %+v", caps)
+	}
+	if caps.Inheritable != 0 || caps.Ambient != 0 {
+		t.Errorf("expected empty inheritable and ambient sets, got %+v", caps)
+	}
+
+	caps, err = (&Process{Pid: 2}).Capabilities()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"CAP_KILL"}; !reflect.DeepEqual(caps.Effective.Names(), exp) {
+		t.Errorf("expected %v, got %v", exp, caps.Effective.Names())
+	}
+	if caps.Bounding != 0 {
+		t.Errorf("expected an empty bounding set, got %x", caps.Bounding)
+	}
+
+	for _, pid := range []int32{3, 4} {
+		if _, err := (&Process{Pid: pid}).Capabilities(); err == nil {
+			t.Errorf("pid %d: expected an error", pid)
+		}
+	}
+
+	if names := CapabilitySet(1 << 45).Names(); !reflect.DeepEqual(names, []string{"CAP_45"}) {
+		t.Errorf("expected an unknown capability to be named by number, got %v", names)
+	}
+}
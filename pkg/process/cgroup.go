@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cgroup returns the path of the process's cgroup, e.g.
+// "/kubepods/burstable/pod1234/abcd", from /proc/<pid>/cgroup.  On a host
+// using cgroup v2 this is the path in the unified hierarchy.  Otherwise it
+// is the path in the first v1 hierarchy listed, which in a container is the
+// same for every hierarchy.
+func (p *Process) Cgroup() (string, error) {
+	data, err := p.readFile("cgroup")
+	if err != nil {
+		return "", err
+	}
+	first := ""
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		// Each line is hierarchy-ID:controllers:path.
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2], nil
+		}
+		if !found {
+			first, found = parts[2], true
+		}
+	}
+	if !found {
+		return "", p.errorf("read cgroup", "no cgroup")
+	}
+	return first, nil
+}
+
+// inCgroup returns true if path is the cgroup prefix or is nested under it.
+func inCgroup(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// SignalProcsInCgroup sends sig to every process in the cgroup cgroupPath, or
+// in a cgroup nested under it, and returns the number of processes signaled.
+// In a pod which shares its PID namespace, this targets one container far
+// more precisely than matching names.  Processes whose cgroup can't be read
+// are skipped.
+func SignalProcsInCgroup(cgroupPath string, sig Signal) (int, error) {
+	if !strings.HasPrefix(cgroupPath, "/") {
+		return 0, fmt.Errorf("invalid cgroup path %q: must be absolute", cgroupPath)
+	}
+	return SignalProcsBy(func(p *Process) (bool, error) {
+		path, err := p.Cgroup()
+		return err == nil && inCgroup(path, cgroupPath), err
+	}, sig)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestCgroup(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "v2"})
+	f.add(fakeProc{pid: 11, name: "v1"})
+	f.add(fakeProc{pid: 12, name: "empty"})
+	f.write("10/cgroup", "0::/kubepods/pod1/c1\n")
+	f.write("11/cgroup", "12:memory:/kubepods/pod2/c1\n11:cpu,cpuacct:/kubepods/pod2/c1\n1:name=systemd:/kubepods/pod2/c1\n")
+	f.write("12/cgroup", "")
+
+	for pid, exp := range map[int32]string{10: "/kubepods/pod1/c1", 11: "/kubepods/pod2/c1"} {
+		path, err := (&Process{Pid: pid}).Cgroup()
+		if err != nil {
+			t.Errorf("pid %d: unexpected error: %v", pid, err)
+			continue
+		}
+		if path != exp {
+			t.Errorf("pid %d: expected %q, got %q", pid, exp, path)
+		}
+	}
+	if _, err := (&Process{Pid: 12}).Cgroup(); err == nil {
+		t.Errorf("expected an error for an empty cgroup file")
+	}
+}
+
+func TestSignalProcsInCgroup(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.add(fakeProc{pid: 12, name: "server"})
+	f.add(fakeProc{pid: 13, name: "server"})
+	f.add(fakeProc{pid: 14, name: "server"})
+	f.write("10/cgroup", "0::/kubepods/pod1/c1\n")
+	f.write("11/cgroup", "0::/kubepods/pod1/c2\n")
+	f.write("12/cgroup", "0::/kubepods/pod12/c1\n")
+	f.write("13/cgroup", "0::/kubepods/pod1\n")
+	// 14 has no cgroup file, so is skipped.
+
+	cases := []struct {
+		path string
+		exp  []int32
+	}{
+		{"/kubepods/pod1/c1", []int32{10}},
+		{"/kubepods/pod1", []int32{10, 11, 13}},
+		{"/kubepods/pod1/", []int32{10, 11, 13}},
+		{"/kubepods/pod3", nil},
+	}
+	for _, tc := range cases {
+		rec := recordSignals(t)
+		defer rec.restore()
+		n, err := SignalProcsInCgroup(tc.path, Signal(syscall.SIGHUP))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.path, err)
+			continue
+		}
+		if got := rec.signaled(); n != len(tc.exp) || !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%q: expected %v, got %d: %v", tc.path, tc.exp, n, got)
+		}
+	}
+
+	if _, err := SignalProcsInCgroup("kubepods", Signal(syscall.SIGHUP)); err == nil {
+		t.Errorf("expected an error for a relative path")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"time"
+)
+
+// Clock is the source of time for everything in this package which waits,
+// polls or measures.  Types with a Clock field use the real clock if it is
+// nil; another can be given, say, so that a test can control time.  The
+// methods of Process, and the ProcFS methods which wait, use the clock given
+// to their ProcFS by WithClock.
+type Clock interface {
+	Now() time.Time
+	// After is like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is a Clock which uses the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// defaultClock is used wherever a clock isn't given explicitly.  Tests
+// replace it.
+var defaultClock Clock = realClock{}
+
+// clockOr returns c, or defaultClock if c is nil.
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return defaultClock
+	}
+	return c
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock which only moves when told to.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	// changed is signaled whenever a waiter is added.
+	changed *sync.Cond
+	// prev is the defaultClock replaced by useFakeClock.
+	prev Clock
+}
+
+type fakeWaiter struct {
+	when time.Time
+	ch   chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{now: time.Unix(1000000, 0)}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// useFakeClock makes a new fakeClock the default, until restore is called.
+func useFakeClock() *fakeClock {
+	c := newFakeClock()
+	c.prev = defaultClock
+	defaultClock = c
+	return c
+}
+
+// restore puts back the default clock which useFakeClock replaced.
+func (c *fakeClock) restore() {
+	defaultClock = c.prev
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	c.changed.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward by d, firing any waiters which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.when.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// BlockUntil waits until at least n waiters are pending, so that a goroutine
+// under test is known to be waiting before the clock is advanced.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.changed.Wait()
+	}
+}
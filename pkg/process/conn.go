@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ProcessFromConn returns the process at the other end of a unix socket,
+// from the credentials the kernel recorded for it when the connection was
+// made.  Unlike matching by name, this can't be fooled by an unrelated
+// process which happens to share the name.  The PID is in the caller's PID
+// namespace, so HOST_PROC should be that namespace's proc filesystem.
+func ProcessFromConn(conn *net.UnixConn) (*Process, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("failed to get peer credentials: %v", credErr)
+	}
+	if cred.Pid == 0 {
+		// Not connected, or the peer is in a PID namespace we can't see.
+		return nil, fmt.Errorf("peer PID is unknown: %w", ErrProcessNotFound)
+	}
+
+	p, err := NewProcess(cred.Pid)
+	if err != nil {
+		return nil, err
+	}
+	// Pin the process's identity as soon as possible, so that Replaced can
+	// tell if its PID is reused.
+	if _, err := p.CreateTime(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
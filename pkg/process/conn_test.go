@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// unixConn wraps fd, one end of a socketpair, in a *net.UnixConn.
+func unixConn(t *testing.T, fd int) *net.UnixConn {
+	t.Helper()
+	file := os.NewFile(uintptr(fd), "socketpair")
+	defer file.Close()
+	conn, err := net.FileConn(file)
+	if err != nil {
+		t.Fatalf("failed to make conn: %v", err)
+	}
+	return conn.(*net.UnixConn)
+}
+
+func TestProcessFromConn(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("failed to create socketpair: %v", err)
+	}
+	ours := unixConn(t, fds[0])
+	defer ours.Close()
+	defer unixConn(t, fds[1]).Close()
+
+	p, err := ProcessFromConn(ours)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Pid != int32(os.Getpid()) {
+		t.Errorf("expected our own pid %d, got %d", os.Getpid(), p.Pid)
+	}
+	if same, err := p.Equal(Self()); err != nil || !same {
+		t.Errorf("expected the peer to be us: %v", err)
+	}
+
+	// A socket which was never connected has no peer.
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	lonely := unixConn(t, fd)
+	defer lonely.Close()
+	if _, err := ProcessFromConn(lonely); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
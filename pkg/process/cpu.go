@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// cpuSetSize is the number of CPUs a glibc cpu_set_t can describe.
+const cpuSetSize = 1024
+
+// CPUAffinity returns the CPUs the process may run on, in increasing order.
+// This asks the kernel directly rather than reading the proc filesystem, so
+// the PID must be valid in the caller's PID namespace.
+func (p *Process) CPUAffinity() ([]int, error) {
+	var mask [cpuSetSize / 64]uint64
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY,
+		uintptr(p.Pid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno == syscall.ESRCH {
+		return nil, p.wrapErr("get CPU affinity", ErrProcessNotFound)
+	}
+	if errno != 0 {
+		return nil, p.wrapErr("get CPU affinity", errno)
+	}
+
+	var cpus []int
+	for i, word := range mask {
+		for bit := 0; bit < 64; bit++ {
+			if word&(1<<uint(bit)) != 0 {
+				cpus = append(cpus, i*64+bit)
+			}
+		}
+	}
+	return cpus, nil
+}
+
+// LastCPU returns the CPU the process last ran on, from the processor field
+// of /proc/<pid>/stat.  Kernels before 2.2.8 don't have that field, in which
+// case an error is returned.
+func (p *Process) LastCPU() (int32, error) {
+	cpu, err := p.statInt(39, "processor", 32)
+	return int32(cpu), err
+}
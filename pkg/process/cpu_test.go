@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCPUAffinity(t *testing.T) {
+	cpus, err := (&Process{Pid: int32(os.Getpid())}).CPUAffinity()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The Go runtime sizes itself from the same affinity mask.
+	if len(cpus) != runtime.NumCPU() {
+		t.Errorf("expected %d CPUs, got %v", runtime.NumCPU(), cpus)
+	}
+	if !sort.IntsAreSorted(cpus) {
+		t.Errorf("expected CPUs to be sorted, got %v", cpus)
+	}
+
+	// Larger than any pid_max.
+	if _, err := (&Process{Pid: 1 << 30}).CPUAffinity(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestLastCPU(t *testing.T) {
+	self, err := Self().LastCPU()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if self < 0 || int(self) >= cpuSetSize {
+		t.Errorf("unexpected CPU %d", self)
+	}
+
+	f := newFixture(t)
+	defer f.cleanup()
+	// Fields 3 to 38, then processor and a couple of later fields.
+	fields := []string{"S"}
+	for n := 4; n <= 38; n++ {
+		fields = append(fields, "0")
+	}
+	fields = append(fields, "3", "0", "0")
+	f.write("42/stat", "42 (a) "+strings.Join(fields, " ")+"\n")
+	f.write("43/stat", "43 (old) S 1 43 43 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 1 0 250\n")
+
+	if cpu, err := (&Process{Pid: 42}).LastCPU(); err != nil || cpu != 3 {
+		t.Errorf("expected CPU 3, got %d: %v", cpu, err)
+	}
+	if cpu, err := (&Process{Pid: 43}).LastCPU(); err == nil {
+		t.Errorf("expected an error for a missing processor field, got %d", cpu)
+	}
+}
@@ -0,0 +1,480 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// SignalOptions controls which of the processes matched by
+// SignalProcsDetailed are signaled, and how.
+type SignalOptions struct {
+	// OldestOnly signals only the matching process with the earliest start
+	// time.  This is useful for pre-forked servers, where signaling the
+	// master is enough to reload all of its workers.
+	OldestOnly bool
+	// StopAtFirst stops scanning at the first matching process, and
+	// signals only that one, for setups where exactly one process should
+	// match.  Processes are scanned in increasing PID order, so this is the
+	// match with the lowest PID, which is not necessarily the oldest; use
+	// OldestOnly for that.  The two can't be combined.
+	StopAtFirst bool
+	// NameStrategy says where each process's name comes from, for
+	// matching.  The default is NameAuto, as Name uses.
+	NameStrategy NameStrategy
+	// DescendantOf, if non-zero, restricts matches to descendants of the
+	// process with this PID.
+	DescendantOf int32
+	// MinAge, if non-zero, restricts matches to processes which started at
+	// least this long ago, to leave alone workers which may still be
+	// initializing.  Processes whose age can't be read are skipped, and
+	// listed in SignalResult.AgeUnknown.
+	MinAge time.Duration
+	// ConfirmHandler checks, after signaling, whether each process has a
+	// handler installed for the signal.  Those which don't are listed in
+	// SignalResult.Unhandled, since the signal's default action (often to
+	// terminate) applies to them rather than, say, a reload.
+	ConfirmHandler bool
+	// RequireHandler checks, before signaling, that each process has a
+	// handler installed for the signal, or ignores it, if the signal's
+	// default action is to terminate, as with SIGHUP or SIGUSR1.  If any
+	// doesn't, nothing is sent and an error names them, so that a reload
+	// signal can't kill a server which doesn't expect it.  Signals which
+	// don't terminate by default, such as SIGWINCH, are always sent.  It
+	// can't be used with SIGKILL, which can't be handled.
+	RequireHandler bool
+	// CheckBlocked checks, after signaling, whether each process has the
+	// signal blocked.  Those which do are listed in SignalResult.Blocked,
+	// since they won't act on it until they unblock it, which may explain a
+	// reload which seems to have no effect.  Only the main thread's mask is
+	// checked.
+	CheckBlocked bool
+	// ConfirmExit, if non-zero, waits up to this long after signaling for
+	// the signaled processes to exit, as SIGTERM or SIGKILL should make
+	// them do.  Those still running afterwards, perhaps because they
+	// ignore the signal, are listed in SignalResult.StillAlive, so that the
+	// caller knows to escalate.  A zombie counts as having exited.
+	ConfirmExit time.Duration
+	// FailOnPermissionDenied makes failing to signal a process for lack of
+	// permission an error, as when running unprivileged alongside processes
+	// owned by root.  By default such processes are skipped, and listed in
+	// SignalResult.PermissionDenied.
+	FailOnPermissionDenied bool
+	// IncludeCmdline records the command line of each matched process in
+	// SignalResult.Cmdlines, for audit logs.  It is off by default, since
+	// it means another read per match.
+	IncludeCmdline bool
+	// CheckPermissions predicts, before signaling, whether we may signal
+	// each process, by comparing its user IDs with ours as kill(2) does.
+	// Those which clearly can't be signaled are not tried, and are listed
+	// in SignalResult.WouldBeDenied.  Processes whose user IDs can't be
+	// read are tried as usual.
+	CheckPermissions bool
+	// SortByAge signals the matching processes in order of start time,
+	// oldest first, for reloads where older workers should go first.
+	// Processes whose start time can't be read are signaled last, in PID
+	// order.
+	SortByAge bool
+	// NewestFirst reverses the order of SortByAge, so that the newest
+	// processes are signaled first.  Processes whose start time can't be
+	// read are still signaled last.
+	NewestFirst bool
+	// Clock, if set, is used instead of the real clock for MinAge and
+	// ConfirmExit.
+	Clock Clock
+}
+
+// SignalResult describes what SignalProcsDetailed did.  Each list of PIDs is
+// in increasing order, except that Signaled is in the order the processes
+// were signaled if SignalOptions.SortByAge is set.
+type SignalResult struct {
+	// Matched holds the PIDs of every process with the requested name which
+	// passed the filters in SignalOptions.
+	Matched []int32
+	// Signaled holds the PIDs of the processes which were signaled.
+	Signaled []int32
+	// Unhandled holds the PIDs of signaled processes which had no handler
+	// for the signal.  It is only filled in if SignalOptions.ConfirmHandler
+	// is set.
+	Unhandled []int32
+	// Blocked holds the PIDs of signaled processes which had the signal
+	// blocked.  It is only filled in if SignalOptions.CheckBlocked is set.
+	Blocked []int32
+	// PermissionDenied holds the PIDs of processes which were skipped
+	// because we may not signal them.  It is always empty if
+	// SignalOptions.FailOnPermissionDenied is set.
+	PermissionDenied []int32
+	// Cmdlines holds the command line of each matched process, by PID.  It
+	// is only filled in if SignalOptions.IncludeCmdline is set, and leaves
+	// out processes whose command line couldn't be read.
+	Cmdlines map[int32][]string
+	// AgeUnknown holds the PIDs of processes with the requested name which
+	// were skipped because SignalOptions.MinAge was set and their age
+	// couldn't be read.
+	AgeUnknown []int32
+	// WouldBeDenied holds the PIDs of processes which were not tried
+	// because SignalOptions.CheckPermissions predicted that we may not
+	// signal them.  If SignalOptions.FailOnPermissionDenied is also set,
+	// any such process makes SignalProcsDetailed return an error.
+	WouldBeDenied []int32
+	// StillAlive holds the PIDs of signaled processes which had not exited
+	// by the time SignalOptions.ConfirmExit ran out.  It is only filled in
+	// if ConfirmExit is set.
+	StillAlive []int32
+}
+
+// SignalProcsDetailed is SignalProcs with options, which reports exactly
+// which processes were matched and signaled.  The result is always non-nil,
+// even if an error is returned.
+func SignalProcsDetailed(ctx context.Context, name string, sig Signal, opts SignalOptions) (*SignalResult, error) {
+	result := &SignalResult{}
+	if err := sig.valid(); err != nil {
+		return result, err
+	}
+	if opts.StopAtFirst && opts.OldestOnly {
+		return result, fmt.Errorf("StopAtFirst and OldestOnly can't be combined")
+	}
+	if opts.NameStrategy < NameAuto || opts.NameStrategy > NameArgv0Base {
+		return result, fmt.Errorf("invalid name strategy %v", opts.NameStrategy)
+	}
+	if opts.RequireHandler && (sig == Signal(syscall.SIGKILL) || sig == Signal(syscall.SIGSTOP)) {
+		return result, fmt.Errorf("RequireHandler can't be used with %v, which can't be handled", sig)
+	}
+	procs, err := matching(ctx, name, opts, result)
+	if err != nil {
+		return result, err
+	}
+	for _, p := range procs {
+		result.Matched = append(result.Matched, p.Pid)
+	}
+	if opts.IncludeCmdline {
+		result.Cmdlines = map[int32][]string{}
+		for _, p := range procs {
+			if args, err := p.CmdlineSlice(); err == nil {
+				result.Cmdlines[p.Pid] = args
+			}
+		}
+	}
+
+	targets := procs
+	if opts.OldestOnly {
+		targets = nil
+		if p := oldest(procs); p != nil {
+			targets = []*Process{p}
+		}
+	}
+	if opts.SortByAge {
+		targets = byAge(targets, opts.NewestFirst)
+	}
+	if opts.CheckPermissions {
+		targets, result.WouldBeDenied = permitted(targets)
+	}
+	if opts.RequireHandler {
+		missing, err := withoutHandler(targets, sig)
+		if err != nil {
+			return result, err
+		}
+		if len(missing) > 0 {
+			return result, fmt.Errorf("not sending %v, which would terminate pids %v: they have no handler for it", sig, missing)
+		}
+	}
+	if opts.ConfirmExit > 0 {
+		// Read start times first, so that a PID reused after the signal
+		// is seen as an exit.
+		for _, p := range targets {
+			p.CreateTime()
+		}
+	}
+	result.Signaled, result.PermissionDenied, err = signalEach(ctx, targets, syscall.Signal(sig), !opts.FailOnPermissionDenied)
+	if err == nil && opts.FailOnPermissionDenied && len(result.WouldBeDenied) > 0 {
+		err = fmt.Errorf("not permitted to send %v to pids %v", sig, result.WouldBeDenied)
+	}
+	if opts.ConfirmExit > 0 && len(result.Signaled) > 0 {
+		var waitErr error
+		result.StillAlive, waitErr = stillAlive(ctx, clockOr(opts.Clock), targets, result.Signaled, opts.ConfirmExit)
+		if err == nil {
+			err = waitErr
+		}
+	}
+	if opts.ConfirmHandler {
+		result.Unhandled = unhandled(targets, result.Signaled, sig)
+	}
+	if opts.CheckBlocked {
+		result.Blocked = blocked(targets, result.Signaled, sig)
+	}
+	return result, err
+}
+
+// matching returns the processes named name which opts allows to be
+// signaled, before OldestOnly is applied.  Processes skipped for an unknown
+// age are recorded in result.
+func matching(ctx context.Context, name string, opts SignalOptions, result *SignalResult) ([]*Process, error) {
+	var procs []*Process
+	now := clockOr(opts.Clock).Now()
+	err := ForEachProcess(ctx, func(p *Process) bool {
+		n, err := p.ResolveName(opts.NameStrategy)
+		if err != nil {
+			countSkip(err)
+			return true
+		}
+		if n != name {
+			return true
+		}
+		if opts.DescendantOf != 0 {
+			if ok, err := p.IsChildOf(opts.DescendantOf); err != nil || !ok {
+				return true
+			}
+		}
+		if opts.MinAge > 0 {
+			age, err := p.ageAt(now)
+			if err != nil {
+				result.AgeUnknown = append(result.AgeUnknown, p.Pid)
+				return true
+			}
+			if age < opts.MinAge {
+				return true
+			}
+		}
+		procs = append(procs, p)
+		return !opts.StopAtFirst
+	})
+	return procs, err
+}
+
+// credentials are the user IDs and privilege which decide whether we may
+// signal a process.
+type credentials struct {
+	real, effective uint32
+	// privileged is set if we may signal any process, as root or with
+	// CAP_KILL.
+	privileged bool
+}
+
+// ownCredentials returns our credentials.  Tests replace it to pretend to
+// be another user.
+var ownCredentials = func() credentials {
+	creds := credentials{real: uint32(syscall.Getuid()), effective: uint32(syscall.Geteuid())}
+	if creds.effective == 0 {
+		creds.privileged = true
+		return creds
+	}
+	// Our own proc directory, even if HOST_PROC points elsewhere.
+	self := &Process{Pid: int32(os.Getpid()), fs: NewProcFS("/proc")}
+	caps, err := self.Capabilities()
+	// If in doubt, assume that we may, and let kill(2) decide.
+	creds.privileged = err != nil || caps.Effective&(1<<capKill) != 0
+	return creds
+}
+
+// capKill is the bit number of CAP_KILL.
+const capKill = 5
+
+// mayKill returns true if, as in kill(2), a sender with these credentials
+// may signal a process whose real and saved user IDs are real and saved: it
+// must be privileged, or its real or effective user ID must match one of
+// them.
+func (c credentials) mayKill(real, saved uint32) bool {
+	return c.privileged || c.real == real || c.real == saved || c.effective == real || c.effective == saved
+}
+
+// CanSignal returns true if we may signal target, because we are root or
+// have CAP_KILL, or because one of our user IDs matches the target's.  It is
+// for checking that git-sync, running with a dropped UID, will be able to
+// signal its target, rather than finding out from EPERM.  Restrictions from
+// security modules such as SELinux or seccomp are not taken into account.
+func CanSignal(target *Process) (bool, error) {
+	creds := ownCredentials()
+	if creds.privileged {
+		return true, nil
+	}
+	real, _, saved, err := target.Uids()
+	if err != nil {
+		return false, err
+	}
+	return creds.mayKill(real, saved), nil
+}
+
+// permitted splits procs into those we may signal, as far as we can tell,
+// and the PIDs of those we clearly may not.
+func permitted(procs []*Process) ([]*Process, []int32) {
+	creds := ownCredentials()
+	if creds.privileged {
+		return procs, nil
+	}
+	var allowed []*Process
+	var denied []int32
+	for _, p := range procs {
+		real, _, saved, err := p.Uids()
+		if err == nil && !creds.mayKill(real, saved) {
+			denied = append(denied, p.Pid)
+			continue
+		}
+		allowed = append(allowed, p)
+	}
+	return allowed, denied
+}
+
+// stillAlive waits up to timeout, as told by c, for the processes in procs
+// whose PIDs are in signaled to exit, and returns the PIDs of those which
+// haven't.
+func stillAlive(ctx context.Context, c Clock, procs []*Process, signaled []int32, timeout time.Duration) ([]int32, error) {
+	wanted := map[int32]bool{}
+	for _, pid := range signaled {
+		wanted[pid] = true
+	}
+	deadline := c.Now().Add(timeout)
+	var alive []int32
+	for _, p := range procs {
+		if !wanted[p.Pid] {
+			continue
+		}
+		err := p.waitForExit(ctx, c, DefaultPollInterval, true, c.After(deadline.Sub(c.Now())))
+		if err == errExpired {
+			alive = append(alive, p.Pid)
+			continue
+		}
+		if err != nil && ctx.Err() != nil {
+			return alive, err
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i] < alive[j] })
+	return alive, nil
+}
+
+// withoutHandler returns the PIDs of procs which sig would terminate, since
+// they neither handle nor ignore it.  Processes which have exited are left
+// out, but one whose handlers can't be read is an error.
+func withoutHandler(procs []*Process, sig Signal) ([]int32, error) {
+	if !sig.terminatesByDefault() {
+		return nil, nil
+	}
+	var missing []int32
+	for _, p := range procs {
+		caught, err := p.signalMask("SigCgt")
+		if err == nil && !caught.Has(sig) {
+			var ignored SignalMask
+			ignored, err = p.signalMask("SigIgn")
+			if err == nil && !ignored.Has(sig) {
+				missing = append(missing, p.Pid)
+			}
+		}
+		if errors.Is(err, ErrProcessNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't check for a handler for %v: %w", sig, err)
+		}
+	}
+	return missing, nil
+}
+
+// unhandled returns the PIDs of the processes in procs whose PIDs are in
+// signaled, and which have no handler for sig.  Processes which can't be
+// read, e.g. because the signal killed them, are left out.
+func unhandled(procs []*Process, signaled []int32, sig Signal) []int32 {
+	return filterByMask(procs, signaled, "SigCgt", sig, false)
+}
+
+// blocked returns the PIDs of the processes in procs whose PIDs are in
+// signaled, and which have sig blocked.  Processes which can't be read are
+// left out.
+func blocked(procs []*Process, signaled []int32, sig Signal) []int32 {
+	return filterByMask(procs, signaled, "SigBlk", sig, true)
+}
+
+// filterByMask returns the sorted PIDs of the processes in procs whose PIDs
+// are in signaled, and whose signal mask in the given status field has sig
+// set, if want is true, or not set, if want is false.
+func filterByMask(procs []*Process, signaled []int32, field string, sig Signal, want bool) []int32 {
+	wanted := map[int32]bool{}
+	for _, pid := range signaled {
+		wanted[pid] = true
+	}
+	var found []int32
+	for _, p := range procs {
+		if !wanted[p.Pid] {
+			continue
+		}
+		// Each PID is only checked once.
+		delete(wanted, p.Pid)
+		mask, err := p.signalMask(field)
+		if err != nil {
+			continue
+		}
+		if mask.Has(sig) == want {
+			found = append(found, p.Pid)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i] < found[j] })
+	return found
+}
+
+// byAge returns procs sorted by start time, oldest first unless newestFirst
+// is set, with ties broken by PID.  Processes whose start time can't be read
+// go last, in their original order.
+func byAge(procs []*Process, newestFirst bool) []*Process {
+	type aged struct {
+		p     *Process
+		start int64
+	}
+	var known []aged
+	var unknown []*Process
+	for _, p := range procs {
+		start, err := p.CreateTime()
+		if err != nil {
+			unknown = append(unknown, p)
+			continue
+		}
+		known = append(known, aged{p, start})
+	}
+	sort.SliceStable(known, func(i, j int) bool {
+		a, b := known[i], known[j]
+		if a.start != b.start {
+			return (a.start < b.start) != newestFirst
+		}
+		return a.p.Pid < b.p.Pid
+	})
+	sorted := make([]*Process, 0, len(procs))
+	for _, a := range known {
+		sorted = append(sorted, a.p)
+	}
+	return append(sorted, unknown...)
+}
+
+// oldest returns the process in procs with the earliest start time, breaking
+// ties by PID.  Processes whose start time can't be read are ignored, and nil
+// is returned if there are none left.
+func oldest(procs []*Process) *Process {
+	var found *Process
+	var foundStart int64
+	for _, p := range procs {
+		start, err := p.CreateTime()
+		if err != nil {
+			continue
+		}
+		if found == nil || start < foundStart || (start == foundStart && p.Pid < found.Pid) {
+			found, foundStart = p, start
+		}
+	}
+	return found
+}
@@ -0,0 +1,766 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// signalRecorder stands in for sendSignal, so that processes in a fixture
+// can be "signaled" without touching real processes.
+type signalRecorder struct {
+	mu   sync.Mutex
+	pids []int32
+	sigs []syscall.Signal
+	// errs, if set, is returned instead of success for the given PIDs.
+	errs map[int32]error
+	// orig is the sendSignal replaced, which restore puts back.
+	orig func(*Process, context.Context, syscall.Signal) error
+}
+
+func recordSignals(t *testing.T) *signalRecorder {
+	r := &signalRecorder{errs: map[int32]error{}, orig: sendSignal}
+	sendSignal = func(p *Process, ctx context.Context, sig syscall.Signal) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if err, ok := r.errs[p.Pid]; ok {
+			return err
+		}
+		r.pids = append(r.pids, p.Pid)
+		r.sigs = append(r.sigs, sig)
+		return nil
+	}
+	return r
+}
+
+// restore stops recording, putting back the sendSignal replaced.
+func (r *signalRecorder) restore() {
+	sendSignal = r.orig
+}
+
+func (r *signalRecorder) signaled() []int32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int32(nil), r.pids...)
+}
+
+// sent returns the signals sent, in order.
+func (r *signalRecorder) sent() []syscall.Signal {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]syscall.Signal(nil), r.sigs...)
+}
+
+func sortedPids(pids []int32) []int32 {
+	sorted := append([]int32(nil), pids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+func TestSignalProcsDetailed(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "worker", start: 300})
+	f.add(fakeProc{pid: 11, name: "worker", start: 100})
+	f.add(fakeProc{pid: 12, name: "worker", start: 200})
+	f.add(fakeProc{pid: 13, name: "other", start: 50})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []int32{10, 11, 12}
+	if got := sortedPids(result.Matched); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected matched %v, got %v", exp, got)
+	}
+	if got := sortedPids(result.Signaled); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected signaled %v, got %v", exp, got)
+	}
+	if got := sortedPids(rec.signaled()); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected signals sent to %v, got %v", exp, got)
+	}
+}
+
+func TestSignalProcsDetailedOldestOnly(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "worker", start: 300})
+	f.add(fakeProc{pid: 11, name: "worker", start: 100})
+	f.add(fakeProc{pid: 12, name: "worker", start: 200})
+	// Older, but not a match.
+	f.add(fakeProc{pid: 13, name: "other", start: 50})
+	// Same start time as 11, so loses the tie on PID.
+	f.add(fakeProc{pid: 14, name: "worker", start: 100})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{OldestOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, exp := sortedPids(result.Matched), []int32{10, 11, 12, 14}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected matched %v, got %v", exp, got)
+	}
+	if exp := []int32{11}; !reflect.DeepEqual(result.Signaled, exp) {
+		t.Errorf("expected signaled %v, got %v", exp, result.Signaled)
+	}
+	if exp := []int32{11}; !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected signals sent to %v, got %v", exp, rec.signaled())
+	}
+}
+
+func TestSignalProcsDetailedNoMatch(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "worker", start: 300})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "nginx", Signal(syscall.SIGHUP), SignalOptions{OldestOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Matched) != 0 || len(result.Signaled) != 0 || len(rec.signaled()) != 0 {
+		t.Errorf("expected nothing to be matched or signaled, got %+v", result)
+	}
+}
+
+func TestSignalProcsDetailedDescendantOf(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	addTree(f)
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{DescendantOf: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []int32{20, 21}
+	if got := sortedPids(result.Matched); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected matched %v, got %v", exp, got)
+	}
+	if got := sortedPids(rec.signaled()); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected signals sent to %v, got %v", exp, got)
+	}
+}
+
+func TestSignalProcsDetailedConfirmHandler(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.add(fakeProc{pid: 12, name: "server"})
+	// 10 handles SIGHUP, 11 only SIGTERM, and 12 has exited by the time
+	// its status is checked.
+	f.write("10/status", "Name:\tserver\nSigCgt:\t0000000000004001\n")
+	f.write("11/status", "Name:\tserver\nSigCgt:\t0000000000004000\n")
+	rec := recordSignals(t)
+	defer rec.restore()
+	orig := sendSignal
+	sendSignal = func(p *Process, ctx context.Context, sig syscall.Signal) error {
+		err := orig(p, ctx, sig)
+		if p.Pid == 12 {
+			os.RemoveAll(filepath.Join(f.root, "12"))
+		}
+		return err
+	}
+
+	result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{ConfirmHandler: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, exp := sortedPids(rec.signaled()), []int32{10, 11, 12}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected signals sent to %v, got %v", exp, got)
+	}
+	if exp := []int32{11}; !reflect.DeepEqual(result.Unhandled, exp) {
+		t.Errorf("expected unhandled %v, got %v", exp, result.Unhandled)
+	}
+
+	result, err = SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Unhandled) != 0 {
+		t.Errorf("expected no handler check unless asked for, got %v", result.Unhandled)
+	}
+}
+
+func TestSignalProcsDetailedRequireHandler(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.add(fakeProc{pid: 12, name: "server"})
+	masks := func(pid int, caught, ignored string) {
+		f.write(fmt.Sprintf("%d/status", pid), fmt.Sprintf("Name:\tserver\nSigIgn:\t%s\nSigCgt:\t%s\n", ignored, caught))
+	}
+	// 10 handles SIGHUP and SIGTERM, 11 ignores SIGHUP and handles
+	// SIGUSR1, and 12 handles only SIGTERM.
+	masks(10, "0000000000004001", "0000000000000000")
+	masks(11, "0000000000000200", "0000000000000001")
+	masks(12, "0000000000004000", "0000000000000000")
+	rec := recordSignals(t)
+	defer rec.restore()
+	ctx := context.Background()
+
+	// Nothing is sent if any would be killed.
+	for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1} {
+		result, err := SignalProcsDetailed(ctx, "server", Signal(sig), SignalOptions{RequireHandler: true})
+		if err == nil || !strings.Contains(err.Error(), "pids [") {
+			t.Errorf("%v: expected an error naming the unprepared pids, got %v", sig, err)
+		}
+		if len(result.Signaled) != 0 || len(rec.signaled()) != 0 {
+			t.Fatalf("%v: expected nothing signaled, got %v", sig, rec.signaled())
+		}
+	}
+	if _, err := SignalProcsDetailed(ctx, "server", Signal(syscall.SIGUSR1), SignalOptions{RequireHandler: true}); err == nil || !strings.Contains(err.Error(), "[10 12]") {
+		t.Errorf("expected pids 10 and 12 to be named, got %v", err)
+	}
+
+	// Once all are ready, all are signaled.
+	masks(12, "0000000000004001", "0000000000000000")
+	result, err := SignalProcsDetailed(ctx, "server", Signal(syscall.SIGHUP), SignalOptions{RequireHandler: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10, 11, 12}; !reflect.DeepEqual(sortedPids(result.Signaled), exp) {
+		t.Errorf("expected %v signaled, got %v", exp, result.Signaled)
+	}
+
+	// A signal which doesn't terminate by default needs no handler.
+	result, err = SignalProcsDetailed(ctx, "server", Signal(syscall.SIGWINCH), SignalOptions{RequireHandler: true})
+	if err != nil || len(result.Signaled) != 3 {
+		t.Errorf("expected SIGWINCH to be sent to all, got %v: %v", result.Signaled, err)
+	}
+	// Nor does anything without the option.
+	masks(12, "0000000000000000", "0000000000000000")
+	result, err = SignalProcsDetailed(ctx, "server", Signal(syscall.SIGUSR1), SignalOptions{})
+	if err != nil || len(result.Signaled) != 3 {
+		t.Errorf("expected SIGUSR1 to be sent to all, got %v: %v", result.Signaled, err)
+	}
+
+	if _, err := SignalProcsDetailed(ctx, "server", Signal(syscall.SIGKILL), SignalOptions{RequireHandler: true}); err == nil {
+		t.Errorf("expected an error for SIGKILL")
+	}
+	f.write("12/status", "Name:\tserver\n")
+	if _, err := SignalProcsDetailed(ctx, "server", Signal(syscall.SIGTERM), SignalOptions{RequireHandler: true}); err == nil {
+		t.Errorf("expected an error when handlers can't be read")
+	}
+}
+
+func TestFilterByMaskProcFS(t *testing.T) {
+	// The masks are read from the processes' own proc filesystem, not
+	// HOST_PROC.
+	f := newRootFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.write("10/status", "Name:\tserver\nSigCgt:\t0000000000000001\nSigBlk:\t0000000000000001\n")
+	f.write("11/status", "Name:\tserver\nSigCgt:\t0000000000000000\nSigBlk:\t0000000000000000\n")
+	fs := NewProcFS(f.root)
+	procs := []*Process{fs.process(11), fs.process(10)}
+
+	if got := blocked(procs, []int32{10, 11}, Signal(syscall.SIGHUP)); !reflect.DeepEqual(got, []int32{10}) {
+		t.Errorf("expected blocked [10], got %v", got)
+	}
+	if got := unhandled(procs, []int32{10, 11}, Signal(syscall.SIGHUP)); !reflect.DeepEqual(got, []int32{11}) {
+		t.Errorf("expected unhandled [11], got %v", got)
+	}
+	if got := unhandled(procs, []int32{10}, Signal(syscall.SIGHUP)); len(got) != 0 {
+		t.Errorf("expected only signaled processes to be checked, got %v", got)
+	}
+}
+
+func TestSignalProcsDetailedCheckBlocked(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.add(fakeProc{pid: 12, name: "server"})
+	// 10 blocks SIGHUP and SIGTERM, 11 only SIGTERM, and 12 has no mask.
+	f.write("10/status", "Name:\tserver\nSigBlk:\t0000000000004001\n")
+	f.write("11/status", "Name:\tserver\nSigBlk:\t0000000000004000\n")
+	defer recordSignals(t).restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{CheckBlocked: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10}; !reflect.DeepEqual(result.Blocked, exp) {
+		t.Errorf("expected blocked %v, got %v", exp, result.Blocked)
+	}
+	result, err = SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGTERM), SignalOptions{CheckBlocked: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10, 11}; !reflect.DeepEqual(result.Blocked, exp) {
+		t.Errorf("expected blocked %v, got %v", exp, result.Blocked)
+	}
+
+	result, err = SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Blocked) != 0 {
+		t.Errorf("expected no blocked check unless asked for, got %v", result.Blocked)
+	}
+}
+
+func TestSignalProcsDetailedPermissionDenied(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.add(fakeProc{pid: 12, name: "server"})
+	f.add(fakeProc{pid: 13, name: "server"})
+	rec := recordSignals(t)
+	defer rec.restore()
+	rec.errs[11] = syscall.EPERM
+	rec.errs[13] = &os.SyscallError{Syscall: "kill", Err: syscall.EPERM}
+
+	result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("expected permission errors to be skipped, got %v", err)
+	}
+	if exp := []int32{10, 12}; !reflect.DeepEqual(result.Signaled, exp) {
+		t.Errorf("expected signaled %v, got %v", exp, result.Signaled)
+	}
+	if exp := []int32{11, 13}; !reflect.DeepEqual(result.PermissionDenied, exp) {
+		t.Errorf("expected permission denied %v, got %v", exp, result.PermissionDenied)
+	}
+
+	result, err = SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{FailOnPermissionDenied: true})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if exp := []int32{10, 12, 10, 12}; !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected the permitted processes to be signaled anyway, got %v", rec.signaled())
+	}
+	if len(result.PermissionDenied) != 0 {
+		t.Errorf("expected no skipped processes, got %v", result.PermissionDenied)
+	}
+}
+
+// pretendToBe makes the detailed signaler believe it is running with creds,
+// returning a function which undoes it.
+func pretendToBe(creds credentials) func() {
+	orig := ownCredentials
+	ownCredentials = func() credentials { return creds }
+	return func() { ownCredentials = orig }
+}
+
+func TestSignalProcsDetailedCheckPermissions(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	f.add(fakeProc{pid: 11, name: "server"})
+	f.add(fakeProc{pid: 12, name: "server"})
+	f.add(fakeProc{pid: 13, name: "server"})
+	// The fixture's processes belong to user 1000.  Pid 11 belongs to root,
+	// and pid 12 has switched to root but kept 1000 as its saved ID.
+	setUids := func(pid int32, uids string) {
+		status, err := (&Process{Pid: pid}).ReadProcFile("status")
+		if err != nil {
+			t.Fatalf("failed to read status: %v", err)
+		}
+		f.write(filepath.Join(strconv.Itoa(int(pid)), "status"),
+			strings.Replace(string(status), "Uid:\t1000\t1000\t1000\t1000", "Uid:\t"+uids, 1))
+	}
+	setUids(11, "0\t0\t0\t0")
+	setUids(12, "0\t0\t1000\t0")
+	// Pid 13's user IDs can't be read, so it is tried anyway.
+	setUids(13, "garbage")
+	rec := recordSignals(t)
+	defer rec.restore()
+	opts := SignalOptions{CheckPermissions: true}
+
+	cases := []struct {
+		name     string
+		creds    credentials
+		signaled []int32
+		denied   []int32
+	}{
+		{"same user", credentials{real: 1000, effective: 1000}, []int32{10, 12, 13}, []int32{11}},
+		{"same real user", credentials{real: 1000, effective: 2000}, []int32{10, 12, 13}, []int32{11}},
+		{"other user", credentials{real: 2000, effective: 2000}, []int32{13}, []int32{10, 11, 12}},
+		{"privileged", credentials{real: 2000, effective: 0, privileged: true}, []int32{10, 11, 12, 13}, nil},
+	}
+	for _, tc := range cases {
+		defer pretendToBe(tc.creds)()
+		before := len(rec.signaled())
+		result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(result.Signaled, tc.signaled) {
+			t.Errorf("%s: expected signaled %v, got %v", tc.name, tc.signaled, result.Signaled)
+		}
+		if !reflect.DeepEqual(result.WouldBeDenied, tc.denied) {
+			t.Errorf("%s: expected would be denied %v, got %v", tc.name, tc.denied, result.WouldBeDenied)
+		}
+		if sent := rec.signaled()[before:]; !reflect.DeepEqual(sent, tc.signaled) {
+			t.Errorf("%s: expected only %v to be tried, got %v", tc.name, tc.signaled, sent)
+		}
+	}
+
+	defer pretendToBe(credentials{real: 2000, effective: 2000})()
+	opts.FailOnPermissionDenied = true
+	result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), opts)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if exp := []int32{13}; !reflect.DeepEqual(result.Signaled, exp) {
+		t.Errorf("expected the other processes to be signaled anyway, got %v", result.Signaled)
+	}
+	if exp := []int32{10, 11, 12}; !reflect.DeepEqual(result.WouldBeDenied, exp) {
+		t.Errorf("expected would be denied %v, got %v", exp, result.WouldBeDenied)
+	}
+}
+
+func TestOwnCredentials(t *testing.T) {
+	creds := ownCredentials()
+	if creds.effective != uint32(os.Geteuid()) || creds.real != uint32(os.Getuid()) {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+	if os.Geteuid() == 0 && !creds.privileged {
+		t.Errorf("expected root to be privileged")
+	}
+}
+
+func TestCanSignal(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// The fixture's processes belong to user 1000.
+	f.add(fakeProc{pid: 10, name: "server"})
+	p := &Process{Pid: 10}
+
+	cases := []struct {
+		name  string
+		creds credentials
+		exp   bool
+	}{
+		{"same user", credentials{real: 1000, effective: 1000}, true},
+		{"same real user", credentials{real: 1000, effective: 2000}, true},
+		{"same effective user", credentials{real: 2000, effective: 1000}, true},
+		{"other user", credentials{real: 2000, effective: 2000}, false},
+		{"root", credentials{real: 0, effective: 0, privileged: true}, true},
+		{"CAP_KILL", credentials{real: 2000, effective: 2000, privileged: true}, true},
+	}
+	for _, tc := range cases {
+		defer pretendToBe(tc.creds)()
+		if ok, err := CanSignal(p); err != nil || ok != tc.exp {
+			t.Errorf("%s: expected %v, got %v: %v", tc.name, tc.exp, ok, err)
+		}
+	}
+
+	defer pretendToBe(credentials{real: 2000, effective: 2000})()
+	if _, err := CanSignal(&Process{Pid: 11}); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestSignalProcsExplainsDenied(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	rec := recordSignals(t)
+	defer rec.restore()
+	rec.errs[10] = os.NewSyscallError("kill", syscall.EPERM)
+
+	defer pretendToBe(credentials{real: 2000, effective: 2000})()
+	_, err := SignalProcs("server", Signal(syscall.SIGHUP))
+	if err == nil || !strings.Contains(err.Error(), "running as uid 2000 (effective 2000) without CAP_KILL, but the process belongs to uid 1000") {
+		t.Errorf("expected the refusal to be explained, got %v", err)
+	}
+
+	// If the user IDs don't explain it, the error is left alone.
+	defer pretendToBe(credentials{real: 1000, effective: 1000})()
+	_, err = SignalProcs("server", Signal(syscall.SIGHUP))
+	if err == nil || strings.Contains(err.Error(), "CAP_KILL") {
+		t.Errorf("expected a plain permission error, got %v", err)
+	}
+}
+
+func TestSignalProcsDetailedSortByAge(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "worker", start: 300})
+	f.add(fakeProc{pid: 11, name: "worker", start: 100})
+	f.add(fakeProc{pid: 12, name: "worker", start: 200})
+	f.add(fakeProc{pid: 13, name: "worker", start: 100})
+	f.add(fakeProc{pid: 14, name: "worker", start: 400})
+	f.add(fakeProc{pid: 15, name: "worker", start: 400})
+	// Ages which can't be read.
+	f.write("14/stat", "14 (worker) S 1\n")
+	f.write("15/stat", "15 (worker) S 1\n")
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	cases := []struct {
+		newestFirst bool
+		order       []int32
+	}{
+		{false, []int32{11, 13, 12, 10, 14, 15}},
+		{true, []int32{10, 12, 11, 13, 14, 15}},
+	}
+	for _, tc := range cases {
+		before := len(rec.signaled())
+		result, err := SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{SortByAge: true, NewestFirst: tc.newestFirst})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sent := rec.signaled()[before:]; !reflect.DeepEqual(sent, tc.order) {
+			t.Errorf("newest first %v: expected signal order %v, got %v", tc.newestFirst, tc.order, sent)
+		}
+		if !reflect.DeepEqual(result.Signaled, tc.order) {
+			t.Errorf("newest first %v: expected signaled %v, got %v", tc.newestFirst, tc.order, result.Signaled)
+		}
+		if exp := []int32{10, 11, 12, 13, 14, 15}; !reflect.DeepEqual(result.Matched, exp) {
+			t.Errorf("newest first %v: expected matched %v in PID order, got %v", tc.newestFirst, exp, result.Matched)
+		}
+	}
+}
+
+func TestSignalProcsDetailedNameStrategy(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "python3", cmdline: []string{"/srv/bin/myapp", "--serve"}})
+	f.add(fakeProc{pid: 11, name: "myapp", cmdline: []string{"myapp"}})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "myapp", Signal(syscall.SIGHUP), SignalOptions{NameStrategy: NameArgv0Base})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10, 11}; !reflect.DeepEqual(result.Signaled, exp) {
+		t.Errorf("expected signaled %v, got %v", exp, result.Signaled)
+	}
+	result, err = SignalProcsDetailed(context.Background(), "myapp", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{11}; !reflect.DeepEqual(result.Signaled, exp) {
+		t.Errorf("expected signaled %v by default, got %v", exp, result.Signaled)
+	}
+
+	before := len(rec.signaled())
+	if _, err := SignalProcsDetailed(context.Background(), "myapp", Signal(syscall.SIGHUP), SignalOptions{NameStrategy: NameStrategy(-1)}); err == nil {
+		t.Errorf("expected an error for an invalid name strategy")
+	}
+	if len(rec.signaled()) != before {
+		t.Errorf("expected nothing signaled for an invalid name strategy")
+	}
+}
+
+func TestSignalProcsDetailedConfirmExit(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skipf("can't find sh: %v", err)
+	}
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skipf("can't find sleep: %v", err)
+	}
+	name := uniqueName("cfx")
+	dir, remove := tempDir(t)
+	defer remove()
+	bin := filepath.Join(dir, name)
+	if err := os.Symlink(sleep, bin); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	// An ignored signal stays ignored across exec.
+	stubborn := exec.Command(sh, "-c", `trap "" TERM; exec "$0" 1000`, bin)
+	if err := stubborn.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer func() {
+		stubborn.Process.Kill()
+		stubborn.Wait()
+	}()
+	polite := exec.Command(bin, "1000")
+	if err := polite.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer func() {
+		polite.Process.Kill()
+		polite.Wait()
+	}()
+	// Wait for sh to exec, so that both have the name.
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if n, err := (&Process{Pid: int32(stubborn.Process.Pid)}).Name(); err == nil && n == name {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child never took the name %q", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := SignalProcsDetailed(context.Background(), name, Signal(syscall.SIGTERM), SignalOptions{ConfirmExit: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Signaled) != 2 {
+		t.Fatalf("expected both processes to be signaled, got %v", result.Signaled)
+	}
+	if exp := []int32{int32(stubborn.Process.Pid)}; !reflect.DeepEqual(result.StillAlive, exp) {
+		t.Errorf("expected %v still alive, got %v", exp, result.StillAlive)
+	}
+	if sig := exitSignal(t, polite); sig != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM, got %v", sig)
+	}
+	if running, err := (&Process{Pid: int32(stubborn.Process.Pid)}).IsRunning(); err != nil || !running {
+		t.Errorf("expected the process ignoring SIGTERM to still be running: %v", err)
+	}
+}
+
+func TestSignalProcsDetailedStopAtFirst(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "other"})
+	f.add(fakeProc{pid: 11, name: "server", start: 200})
+	f.add(fakeProc{pid: 12, name: "server", start: 100})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{StopAtFirst: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 12 matches too, but the scan stopped before reaching it.
+	if exp := []int32{11}; !reflect.DeepEqual(result.Matched, exp) {
+		t.Errorf("expected matched %v, got %v", exp, result.Matched)
+	}
+	if exp := []int32{11}; !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected signals sent to %v, got %v", exp, rec.signaled())
+	}
+
+	if _, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{StopAtFirst: true, OldestOnly: true}); err == nil {
+		t.Errorf("expected an error combining StopAtFirst and OldestOnly")
+	}
+}
+
+func TestSignalProcsDetailedIncludeCmdline(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server", cmdline: []string{"server", "--role=master"}})
+	f.add(fakeProc{pid: 11, name: "server", cmdline: []string{"server", "--role=worker"}})
+	f.add(fakeProc{pid: 12, name: "other", cmdline: []string{"other"}})
+	defer recordSignals(t).restore()
+
+	result, err := SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Cmdlines != nil {
+		t.Errorf("expected no command lines unless asked for, got %v", result.Cmdlines)
+	}
+
+	result, err = SignalProcsDetailed(context.Background(), "server", Signal(syscall.SIGHUP), SignalOptions{IncludeCmdline: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := map[int32][]string{
+		10: {"server", "--role=master"},
+		11: {"server", "--role=worker"},
+	}
+	if !reflect.DeepEqual(result.Cmdlines, exp) {
+		t.Errorf("expected %v, got %v", exp, result.Cmdlines)
+	}
+}
+
+func TestSignalProcsDetailedMinAge(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// Started 10s, 100s and 1000s after boot.
+	f.add(fakeProc{pid: 10, name: "worker", start: 1000})
+	f.add(fakeProc{pid: 11, name: "worker", start: 10000})
+	f.add(fakeProc{pid: 12, name: "worker", start: 100000})
+	f.write("13/status", "Name:\tworker\n")
+	f.write("13/stat", "13 (worker) S\n")
+	rec := recordSignals(t)
+	defer rec.restore()
+	clock := newFakeClock()
+	clock.Advance(time.Unix(fixtureBootTime+1030, 0).Sub(clock.Now()))
+
+	result, err := SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{MinAge: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Ages are 1020s, 930s and 30s.
+	if exp := []int32{10, 11}; !reflect.DeepEqual(result.Matched, exp) || !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v matched and signaled, got %v and %v", exp, result.Matched, rec.signaled())
+	}
+	if exp := []int32{13}; !reflect.DeepEqual(result.AgeUnknown, exp) {
+		t.Errorf("expected age unknown %v, got %v", exp, result.AgeUnknown)
+	}
+
+	result, err = SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10, 11, 12, 13}; !reflect.DeepEqual(result.Matched, exp) {
+		t.Errorf("expected every worker without MinAge, got %v", result.Matched)
+	}
+	if len(result.AgeUnknown) != 0 {
+		t.Errorf("expected no age checks without MinAge, got %v", result.AgeUnknown)
+	}
+}
+
+func TestSignalProcsDetailedStableOrder(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	var exp []int32
+	for pid := int32(100); pid < 150; pid++ {
+		exp = append(exp, pid)
+	}
+	for _, i := range rand.New(rand.NewSource(1)).Perm(len(exp)) {
+		f.add(fakeProc{pid: exp[i], name: "worker"})
+	}
+	defer recordSignals(t).restore()
+
+	for run := 0; run < 5; run++ {
+		result, err := SignalProcsDetailed(context.Background(), "worker", Signal(syscall.SIGHUP), SignalOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(result.Matched, exp) {
+			t.Fatalf("run %d: expected matched %v, got %v", run, exp, result.Matched)
+		}
+		if !reflect.DeepEqual(result.Signaled, exp) {
+			t.Fatalf("run %d: expected signaled %v, got %v", run, exp, result.Signaled)
+		}
+	}
+}
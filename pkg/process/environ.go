@@ -0,0 +1,71 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+)
+
+// Environ returns the environment of the process, as "KEY=value" strings,
+// as it was when the process started.  Changes the process makes to its own
+// environment afterwards are not reflected.  Only the process's owner, or
+// root, may read it.
+func (p *Process) Environ() ([]string, error) {
+	data, err := p.readFile("environ")
+	if err != nil {
+		return nil, err
+	}
+	env := strings.Split(string(data), "\x00")
+	if len(env) > 0 && env[len(env)-1] == "" {
+		env = env[:len(env)-1]
+	}
+	return env, nil
+}
+
+// EnvVar returns the value of the environment variable key, as Environ
+// would, and whether it was set at all.  It stops reading at the first
+// variable named key.
+func (p *Process) EnvVar(key string) (string, bool, error) {
+	file, err := p.openFile("environ")
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	prefix := key + "="
+	r := bufio.NewReader(file)
+	for {
+		entry, err := r.ReadString(0)
+		if strings.HasPrefix(entry, prefix) {
+			return strings.TrimSuffix(entry[len(prefix):], "\x00"), true, nil
+		}
+		if err == io.EOF {
+			return "", false, nil
+		}
+		if err != nil {
+			if errors.Is(err, syscall.ESRCH) {
+				// The process exited while we were reading.
+				err = ErrProcessNotFound
+			}
+			return "", false, p.wrapErr("read environ", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEnviron(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "app"})
+	f.write("42/environ", "PATH=/usr/bin:/bin\x00OPTS=--a=1 --b=2\x00EMPTY=\x00PATH=/shadowed\x00")
+	f.write("43/environ", "")
+	p := &Process{Pid: 42}
+
+	env, err := p.Environ()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"PATH=/usr/bin:/bin", "OPTS=--a=1 --b=2", "EMPTY=", "PATH=/shadowed"}; !reflect.DeepEqual(env, exp) {
+		t.Errorf("expected %q, got %q", exp, env)
+	}
+	if env, err := (&Process{Pid: 43}).Environ(); err != nil || len(env) != 0 {
+		t.Errorf("expected an empty environment, got %q: %v", env, err)
+	}
+
+	cases := []struct {
+		key   string
+		val   string
+		found bool
+	}{
+		{"PATH", "/usr/bin:/bin", true},
+		{"OPTS", "--a=1 --b=2", true},
+		{"EMPTY", "", true},
+		{"OPT", "", false},
+		{"MISSING", "", false},
+	}
+	for _, tc := range cases {
+		val, found, err := p.EnvVar(tc.key)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.key, err)
+			continue
+		}
+		if val != tc.val || found != tc.found {
+			t.Errorf("%s: expected %q, %v, got %q, %v", tc.key, tc.val, tc.found, val, found)
+		}
+	}
+
+	// Without a trailing NUL.
+	f.write("42/environ", "A=1\x00B=x=y")
+	if val, found, err := p.EnvVar("B"); err != nil || !found || val != "x=y" {
+		t.Errorf("expected x=y, got %q, %v: %v", val, found, err)
+	}
+
+	if _, _, err := (&Process{Pid: 44}).EnvVar("PATH"); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+	if _, err := (&Process{Pid: 44}).Environ(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestEnvVarSelf(t *testing.T) {
+	// Our environ file reflects the environment we started with, not any
+	// later Setenv, so look for something inherited.
+	want, ok := os.LookupEnv("PATH")
+	if !ok {
+		t.Skip("no PATH set")
+	}
+	val, found, err := Self().EnvVar("PATH")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !found || val != want {
+		t.Errorf("expected %q, got %q, %v", want, val, found)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrFdNotFound is returned, wrapped in a ProcessError, when a process exists
+// but the file descriptor asked about does not, e.g. because it was closed.
+var ErrFdNotFound = errors.New("file descriptor not found")
+
+// FdInfoStat holds the state of an open file descriptor, from
+// /proc/<pid>/fdinfo/<fd>.
+type FdInfoStat struct {
+	// Pos is the current file offset.
+	Pos int64
+	// Flags are the flags the file was opened with, e.g. os.O_RDWR.
+	Flags int
+}
+
+// FdInfo returns the file position and open flags of fd.
+func (p *Process) FdInfo(fd int) (*FdInfoStat, error) {
+	name := "fdinfo/" + strconv.Itoa(fd)
+	data, err := p.readFile(name)
+	if errors.Is(err, ErrProcessNotFound) {
+		if _, err := p.fs.NewProcess(p.Pid); err == nil {
+			return nil, p.wrapErr("read "+name, ErrFdNotFound)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := parseStatus(data)
+	info := &FdInfoStat{}
+	pos, ok := fields["pos"]
+	if !ok {
+		return nil, p.errorf("read "+name, "no pos")
+	}
+	if info.Pos, err = strconv.ParseInt(pos, 10, 64); err != nil {
+		return nil, p.errorf("read "+name, "malformed pos %q: %v", pos, err)
+	}
+	flags, ok := fields["flags"]
+	if !ok {
+		return nil, p.errorf("read "+name, "no flags")
+	}
+	// The kernel reports flags in octal.
+	f, err := strconv.ParseUint(flags, 8, 32)
+	if err != nil {
+		return nil, p.errorf("read "+name, "malformed flags %q: %v", flags, err)
+	}
+	info.Flags = int(f)
+	return info, nil
+}
+
+// FdTarget returns what fd refers to, as the kernel reports it: a path, as
+// the host sees it (see HostPath), or something like "socket:[1234]" or
+// "pipe:[5678]" for descriptors which aren't files.
+func (p *Process) FdTarget(fd int) (string, error) {
+	target, err := os.Readlink(p.path("fd", strconv.Itoa(fd)))
+	if os.IsNotExist(err) {
+		err = ErrProcessNotFound
+		if _, perr := p.fs.NewProcess(p.Pid); perr == nil {
+			err = ErrFdNotFound
+		}
+	}
+	if err != nil {
+		return "", p.wrapErr("read fd "+strconv.Itoa(fd), err)
+	}
+	return target, nil
+}
+
+// FdTypeCountsStat is the number of file descriptors a process has open, by
+// what they refer to.
+type FdTypeCountsStat struct {
+	Sockets int
+	Pipes   int
+	// Files are descriptors which refer to a path, including directories and
+	// devices.
+	Files int
+	// AnonInodes are descriptors such as eventfds, epoll instances and
+	// timerfds, which have no inode of their own.
+	AnonInodes int
+	// Other are the descriptors of any other kind, e.g. namespaces.
+	Other int
+}
+
+// FdTypeCounts returns the number of file descriptors the process has open
+// of each kind, for telling what a process which is leaking them, e.g. on
+// every reload, is leaking.  Descriptors which are closed while they are
+// counted are skipped.
+func (p *Process) FdTypeCounts() (*FdTypeCountsStat, error) {
+	d, err := p.openFile("fd")
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, p.wrapErr("list fds", err)
+	}
+
+	counts := &FdTypeCountsStat{}
+	for _, name := range names {
+		target, err := os.Readlink(p.path("fd", name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, p.wrapErr("read fd "+name, err)
+		}
+		switch {
+		case strings.HasPrefix(target, "socket:["):
+			counts.Sockets++
+		case strings.HasPrefix(target, "pipe:["):
+			counts.Pipes++
+		case strings.HasPrefix(target, "anon_inode:"):
+			counts.AnonInodes++
+		case strings.HasPrefix(target, "/"):
+			counts.Files++
+		default:
+			counts.Other++
+		}
+	}
+	return counts, nil
+}
+
+// NumFDs returns the number of file descriptors the process has open.
+func (p *Process) NumFDs() (int32, error) {
+	d, err := p.openFile("fd")
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return 0, p.wrapErr("list fds", err)
+	}
+	return int32(len(names)), nil
+}
+
+// FDsNearLimit returns true if the process has at least threshold, a
+// fraction such as 0.9, of its soft limit on open files in use, as
+// nearFDLimit decides.  A process with no limit is never near it.
+func (p *Process) FDsNearLimit(threshold float64) (bool, error) {
+	soft, _, err := p.Limit("Max open files")
+	if err != nil {
+		return false, err
+	}
+	if soft == Unlimited {
+		return false, nil
+	}
+	n, err := p.NumFDs()
+	if err != nil {
+		return false, err
+	}
+	return nearFDLimit(n, soft, threshold), nil
+}
+
+// nearFDLimit returns true if open file descriptors are at least threshold
+// of the soft limit, so that with a threshold of 0.9 and a limit of 10, the
+// ninth is near it.  FDsNearLimit and FDReport both decide by this, so that
+// they agree.
+func nearFDLimit(open int32, soft uint64, threshold float64) bool {
+	return float64(open) >= threshold*float64(soft)
+}
+
+// fdNearFraction is the fraction of the soft limit on open files from
+// which FDReport flags a process as near it.
+const fdNearFraction = 0.9
+
+// FDReportStat is how close a process is to running out of file
+// descriptors.
+type FDReportStat struct {
+	// Open is the number of file descriptors the process has open.
+	Open int32
+	// Soft and Hard are the limits on open files, or Unlimited.
+	Soft, Hard uint64
+	// Used is Open as a fraction of Soft, or 0 if Soft is Unlimited.
+	Used float64
+	// NearLimit is true if Open is at least 90% of Soft, as FDsNearLimit
+	// decides.
+	NearLimit bool
+}
+
+// FDReport returns the number of file descriptors the process has open,
+// together with its limits on them, for spotting a process which is about to
+// run out, e.g. after a reload which leaks descriptors.
+func (p *Process) FDReport() (*FDReportStat, error) {
+	soft, hard, err := p.Limit("Max open files")
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.NumFDs()
+	if err != nil {
+		return nil, err
+	}
+	report := &FDReportStat{Open: n, Soft: soft, Hard: hard}
+	if soft == Unlimited {
+		return report, nil
+	}
+	if soft > 0 {
+		report.Used = float64(n) / float64(soft)
+	}
+	report.NearLimit = nearFDLimit(n, soft, fdNearFraction)
+	return report, nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFdInfo(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("42/fdinfo/3", "pos:\t4096\nflags:\t02002\nmnt_id:\t25\nino:\t1234\n")
+
+	p := &Process{Pid: 42}
+	info, err := p.FdInfo(3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Pos != 4096 {
+		t.Errorf("expected pos 4096, got %d", info.Pos)
+	}
+	if exp := os.O_RDWR | os.O_APPEND; info.Flags != exp {
+		t.Errorf("expected flags %o, got %o", exp, info.Flags)
+	}
+
+	if _, err := p.FdInfo(4); !errors.Is(err, ErrFdNotFound) {
+		t.Errorf("expected ErrFdNotFound, got %v", err)
+	}
+	if _, err := (&Process{Pid: 43}).FdInfo(3); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+
+	f.write("42/fdinfo/5", "flags:\t02\n")
+	if _, err := p.FdInfo(5); err == nil {
+		t.Errorf("expected an error for a missing pos")
+	}
+}
+
+func TestFdTarget(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	targets := map[int]string{
+		0: "/dev/null",
+		3: "socket:[81234]",
+		4: "pipe:[81235]",
+		5: "anon_inode:[eventpoll]",
+		6: "/var/log/nginx/old.log (deleted)",
+	}
+	for fd, target := range targets {
+		f.symlink(target, "42/fd/"+strconv.Itoa(fd))
+	}
+	p := &Process{Pid: 42}
+	for fd, exp := range targets {
+		if target, err := p.FdTarget(fd); err != nil || target != exp {
+			t.Errorf("fd %d: expected %q, got %q: %v", fd, exp, target, err)
+		}
+	}
+
+	// Closed between being listed and being read.
+	if err := os.Remove(filepath.Join(f.root, "42", "fd", "4")); err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range []int{4, 7, -1} {
+		if _, err := p.FdTarget(fd); !errors.Is(err, ErrFdNotFound) {
+			t.Errorf("fd %d: expected ErrFdNotFound, got %v", fd, err)
+		}
+	}
+	if _, err := (&Process{Pid: 43}).FdTarget(0); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestFdTargetSelf(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	path := filepath.Join(dir, "open")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatalf("failed to resolve %s: %v", path, err)
+	}
+	fd := int(file.Fd())
+	if target, err := Self().FdTarget(fd); err != nil || target != resolved {
+		t.Errorf("expected %s, got %q: %v", resolved, target, err)
+	}
+	file.Close()
+	if target, err := Self().FdTarget(fd); !errors.Is(err, ErrFdNotFound) && target == resolved {
+		t.Errorf("expected fd %d to be closed, got %q: %v", fd, target, err)
+	}
+}
+
+func TestFdTypeCounts(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	for fd, target := range []string{
+		"/dev/null",
+		"/dev/null",
+		"/var/log/nginx/access.log",
+		"/var/log/nginx/old.log (deleted)",
+		"socket:[81234]",
+		"socket:[81236]",
+		"socket:[81237]",
+		"pipe:[81235]",
+		"anon_inode:[eventpoll]",
+		"anon_inode:[eventfd]",
+		"net:[4026531992]",
+	} {
+		f.symlink(target, "42/fd/"+strconv.Itoa(fd))
+	}
+
+	counts, err := (&Process{Pid: 42}).FdTypeCounts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := FdTypeCountsStat{Sockets: 3, Pipes: 1, Files: 4, AnonInodes: 2, Other: 1}
+	if *counts != exp {
+		t.Errorf("expected %+v, got %+v", exp, *counts)
+	}
+
+	if _, err := (&Process{Pid: 43}).FdTypeCounts(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestFDsNearLimit(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	limits := func(soft string) {
+		f.write("42/limits", strings.Replace(fixtureLimits, "Max open files            1024 ", "Max open files            "+soft+" ", 1))
+	}
+	openFDs := func(n int) {
+		for fd := 0; fd < n; fd++ {
+			f.write("42/fd/"+strconv.Itoa(fd), "")
+		}
+	}
+	p := &Process{Pid: 42}
+
+	limits("10  ")
+	openFDs(5)
+	if n, err := p.NumFDs(); err != nil || n != 5 {
+		t.Fatalf("expected 5 fds, got %d: %v", n, err)
+	}
+	cases := []struct {
+		fds       int
+		threshold float64
+		near      bool
+	}{
+		{5, 0.0, true},
+		{5, 0.9, false},
+		{8, 0.9, false},
+		{9, 0.9, true},
+		{10, 0.9, true},
+	}
+	for _, tc := range cases {
+		openFDs(tc.fds)
+		near, err := p.FDsNearLimit(tc.threshold)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if near != tc.near {
+			t.Errorf("%d fds at %v: expected %v, got %v", tc.fds, tc.threshold, tc.near, near)
+		}
+	}
+
+	limits("unlimited")
+	for _, threshold := range []float64{0.0, 0.9} {
+		if near, err := p.FDsNearLimit(threshold); err != nil || near {
+			t.Errorf("unlimited at %v: expected not near, got %v: %v", threshold, near, err)
+		}
+	}
+
+	if _, err := (&Process{Pid: 43}).NumFDs(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestFDReport(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	limits := func(soft string) {
+		f.write("42/limits", strings.Replace(fixtureLimits, "Max open files            1024 ", "Max open files            "+soft+" ", 1))
+	}
+	openFDs := func(n int) {
+		dir := filepath.Join(f.root, "42/fd")
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for fd := 0; fd < n; fd++ {
+			f.write("42/fd/"+strconv.Itoa(fd), "")
+		}
+	}
+	p := &Process{Pid: 42}
+
+	limits("20  ")
+	cases := []struct {
+		fds  int
+		used float64
+		near bool
+	}{
+		{0, 0, false},
+		{1, 0.05, false},
+		{10, 0.5, false},
+		{17, 0.85, false},
+		{18, 0.9, true},
+		{20, 1, true},
+		{25, 1.25, true},
+	}
+	for _, tc := range cases {
+		openFDs(tc.fds)
+		report, err := p.FDReport()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		exp := FDReportStat{Open: int32(tc.fds), Soft: 20, Hard: 524288, Used: tc.used, NearLimit: tc.near}
+		if *report != exp {
+			t.Errorf("%d fds: expected %+v, got %+v", tc.fds, exp, *report)
+		}
+	}
+
+	limits("unlimited")
+	openFDs(5)
+	report, err := p.FDReport()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := (FDReportStat{Open: 5, Soft: Unlimited, Hard: 524288}); *report != exp {
+		t.Errorf("unlimited: expected %+v, got %+v", exp, *report)
+	}
+
+	if _, err := (&Process{Pid: 43}).FDReport(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzParseStatus(f *testing.F) {
+	f.Add([]byte("Name:\tnginx\nUmask:\t0022\nState:\tS (sleeping)\nTgid:\t42\nPid:\t42\nPPid:\t1\nSigBlk:\t0000000000000000\nSigCgt:\t0000000188004a03\n"))
+	f.Add([]byte("Name:\tnginx\nState:\tS (sleeping)\n"))
+	f.Add([]byte("Name:\n:\n::\n\n"))
+	f.Add([]byte("Name\tno colon\r\nSigCgt:\tffffffffffffffffff\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		all := parseStatus(data)
+		for key := range all {
+			if strings.Contains(key, "\n") {
+				t.Errorf("key %q spans lines", key)
+			}
+		}
+
+		keys := []string{"Name", "State", "SigCgt"}
+		fields, err := scanStatus(bytes.NewReader(data), keys...)
+		if err != nil {
+			// Only lines too long to scan are an error.
+			return
+		}
+		for key := range fields {
+			if key != "Name" && key != "State" && key != "SigCgt" {
+				t.Errorf("unrequested key %q", key)
+			}
+		}
+		if val, ok := fields["SigCgt"]; ok {
+			// Any value may be malformed, but none may panic.
+			parseSignalMask(val)
+		}
+
+		if stat, ok := parseStat(data); ok {
+			for _, field := range stat {
+				if field == "" || strings.ContainsAny(field, " \t\n") {
+					t.Errorf("bad stat field %q", field)
+				}
+			}
+		}
+	})
+}
+
+func FuzzParseCmdline(f *testing.F) {
+	f.Add([]byte("/bin/server\x00--port=80\x00"), 100)
+	f.Add([]byte("server"), 3)
+	f.Add([]byte("\x00\x00\x00"), 1)
+	f.Add([]byte(""), 0)
+	f.Add([]byte("a\x00b"), -1)
+	f.Fuzz(func(t *testing.T, data []byte, limit int) {
+		args, truncated := parseCmdline(data, limit)
+		if args == nil {
+			t.Fatalf("expected a non-nil slice")
+		}
+		effective := limit
+		if effective < 0 {
+			effective = 0
+		}
+		if truncated != (len(data) > effective) {
+			t.Errorf("truncated=%v for %d bytes with limit %d", truncated, len(data), limit)
+		}
+		if n := len(strings.Join(args, "\x00")); n > len(data) || n > effective {
+			t.Errorf("got %d bytes of arguments from %d bytes with limit %d", n, len(data), limit)
+		}
+		for _, arg := range args {
+			if strings.Contains(arg, "\x00") {
+				t.Errorf("argument %q contains a NUL", arg)
+			}
+		}
+	})
+}
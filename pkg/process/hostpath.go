@@ -0,0 +1,100 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks is how many symlinks resolveIn follows before giving up, as
+// the kernel does with ELOOP.
+const maxSymlinks = 255
+
+// hostRoot returns the directory at which the host's filesystem can be found
+// from here.  It is HOST_ROOT, if that is set.  Otherwise a proc filesystem
+// at some dir/proc, as with HOST_PROC=/host/proc for a sidecar with the
+// host's / mounted at /host, is taken to mean the host's root is at dir.
+// That makes it / for /proc itself.
+func (fs ProcFS) hostRoot() string {
+	if root := os.Getenv("HOST_ROOT"); root != "" {
+		return filepath.Clean(root)
+	}
+	if proc := fs.path(); filepath.Base(proc) == "proc" {
+		return filepath.Dir(proc)
+	}
+	return "/"
+}
+
+// HostPath returns where path, as read from one of the process's links,
+// e.g. by Exe, Cwd, Root or FdTarget, can be found from here.  The kernel
+// reports such paths as they are on the host, which are meaningless in,
+// say, a sidecar, unless interpreted under the host's root (see hostRoot).
+// Targets which aren't paths, such as "socket:[1234]", are returned as is.
+func (p *Process) HostPath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(p.fs.hostRoot(), path)
+}
+
+// resolveIn returns path, which is absolute, with symlinks resolved as they
+// would be by a process whose / is root, so that an absolute link is
+// followed within root, rather than out of it.  The result is relative to
+// root, as path is.
+func resolveIn(root, path string) (string, error) {
+	if root == "/" {
+		return filepath.EvalSymlinks(path)
+	}
+	resolved := "/"
+	pending := strings.Split(path, "/")
+	for links := 0; len(pending) > 0; {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			// Dir never goes above /, so neither does this.
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many links in %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestHostRoot(t *testing.T) {
+	cases := []struct {
+		hostRoot, hostProc string
+		exp                string
+	}{
+		{"", "", "/"},
+		{"", "/proc", "/"},
+		{"", "/host/proc", "/host"},
+		{"", "/host/proc/", "/host"},
+		{"", "/srv/fakeproc", "/"},
+		{"/rootfs/", "/host/proc", "/rootfs"},
+	}
+	defer setenv("HOST_ROOT", "")()
+	defer setenv("HOST_PROC", "")()
+	for _, tc := range cases {
+		os.Setenv("HOST_ROOT", tc.hostRoot)
+		os.Setenv("HOST_PROC", tc.hostProc)
+		if got := (ProcFS{}).hostRoot(); got != tc.exp {
+			t.Errorf("HOST_ROOT=%q HOST_PROC=%q: expected %q, got %q", tc.hostRoot, tc.hostProc, tc.exp, got)
+		}
+	}
+	os.Setenv("HOST_ROOT", "")
+	if got := NewProcFS("/host/proc").hostRoot(); got != "/host" {
+		t.Errorf("NewProcFS: expected /host, got %q", got)
+	}
+}
+
+// hostFixture returns a fake host filesystem, which HOST_ROOT points at until
+// cleanup is called.
+func hostFixture(t *testing.T) *fixture {
+	t.Helper()
+	host := newEmptyFixture(t)
+	host.setenv("HOST_ROOT", host.root)
+	return host
+}
+
+// symlink creates a link at rel under the fixture root, creating parent
+// directories as needed.
+func (f *fixture) symlink(target, rel string) {
+	f.t.Helper()
+	path := filepath.Join(f.root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatalf("failed to create dir for %s: %v", rel, err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		f.t.Fatalf("failed to create link %s: %v", rel, err)
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	host := hostFixture(t)
+	defer host.cleanup()
+	host.write("usr/sbin/nginx", "#!/bin/sh\n")
+	host.write("var/log/nginx/access.log", "")
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.symlink("/usr/sbin/nginx", "42/exe")
+	f.symlink("/var/log/nginx", "42/cwd")
+	f.symlink("/", "42/root")
+	f.symlink("/var/log/nginx/access.log", "42/fd/3")
+	f.symlink("socket:[1234]", "42/fd/4")
+	p := &Process{Pid: 42}
+
+	for name, read := range map[string]func() (string, error){
+		"exe":  p.Exe,
+		"cwd":  p.Cwd,
+		"root": p.Root,
+		"fd 3": func() (string, error) { return p.FdTarget(3) },
+	} {
+		path, err := read()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if _, err := os.Stat(p.HostPath(path)); err != nil {
+			t.Errorf("%s: expected %s to be under the host root: %v", name, p.HostPath(path), err)
+		}
+	}
+	if target, err := p.FdTarget(4); err != nil || p.HostPath(target) != "socket:[1234]" {
+		t.Errorf("expected a socket to be left alone, got %q: %v", p.HostPath(target), err)
+	}
+
+	if _, err := p.FdTarget(5); !errors.Is(err, ErrFdNotFound) {
+		t.Errorf("expected ErrFdNotFound, got %v", err)
+	}
+	q := &Process{Pid: 43}
+	for name, read := range map[string]func() (string, error){
+		"root": q.Root,
+		"fd 3": func() (string, error) { return q.FdTarget(3) },
+	} {
+		if _, err := read(); !errors.Is(err, ErrProcessNotFound) {
+			t.Errorf("%s: expected ErrProcessNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestResolveIn(t *testing.T) {
+	host := newEmptyFixture(t)
+	defer host.cleanup()
+	host.write("opt/app-1.2/bin/app", "#!/bin/sh\n")
+	// Absolute links must be followed within the root, relative ones from
+	// where they are, and neither can climb out of it.
+	host.symlink("/opt/app-1.2", "opt/app")
+	host.symlink("../../opt/app/bin/app", "usr/bin/app")
+	host.symlink("../../../../../../opt/app", "usr/local/app")
+	host.symlink("loop", "loop")
+
+	for path, exp := range map[string]string{
+		"/opt/app-1.2/bin/app":     "/opt/app-1.2/bin/app",
+		"/opt/app/bin/app":         "/opt/app-1.2/bin/app",
+		"/usr/bin/app":             "/opt/app-1.2/bin/app",
+		"/usr/local/app/bin/app":   "/opt/app-1.2/bin/app",
+		"/usr/../../opt/app/./bin": "/opt/app-1.2/bin",
+	} {
+		if got, err := resolveIn(host.root, path); err != nil || got != exp {
+			t.Errorf("%s: expected %s, got %q: %v", path, exp, got, err)
+		}
+	}
+	for _, path := range []string{"/loop", "/opt/app/bin/missing"} {
+		if got, err := resolveIn(host.root, path); err == nil {
+			t.Errorf("%s: expected an error, got %q", path, got)
+		}
+	}
+
+	f := newFixture(t)
+	defer f.cleanup()
+	f.setenv("HOST_ROOT", host.root)
+	for pid, exe := range map[int32]string{10: "/usr/bin/app", 11: "/opt/app-1.2/bin/app"} {
+		f.add(fakeProc{pid: pid, name: "app"})
+		f.symlink(exe, strconv.Itoa(int(pid))+"/exe")
+	}
+	for _, pid := range []int32{10, 11} {
+		if ok, err := (&Process{Pid: pid}).Matches(MatchSpec{Exe: "/opt/app/bin/app", CanonicalExe: true}); err != nil || !ok {
+			t.Errorf("pid %d: expected a match under the host root: %v", pid, err)
+		}
+	}
+}
+
+func TestSignalProcsInDirHostRoot(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	host := hostFixture(t)
+	defer host.cleanup()
+	host.write("srv/app/worktree/sub/main.go", "")
+	host.write("srv/other/main.go", "")
+	for pid, cwd := range map[int32]string{10: "/srv/app/worktree/sub", 11: "/srv/other"} {
+		f.add(fakeProc{pid: pid, name: "app"})
+		f.symlink(cwd, strconv.Itoa(int(pid))+"/cwd")
+	}
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	// The working directories are as the host sees them, and the worktree
+	// as we do.
+	n, err := SignalProcsInDir(filepath.Join(host.root, "srv/app/worktree"), Signal(syscall.SIGHUP))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10}; n != len(exp) || !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v, got %d: %v", exp, n, rec.signaled())
+	}
+}
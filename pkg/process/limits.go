@@ -0,0 +1,70 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+)
+
+// Unlimited is the value of a resource limit reported as "unlimited".
+const Unlimited = math.MaxUint64
+
+// Limit returns the soft and hard values of the resource limit called name
+// in /proc/<pid>/limits, e.g. "Max open files".  Limits which are
+// "unlimited" are returned as Unlimited.
+func (p *Process) Limit(name string) (soft, hard uint64, err error) {
+	data, err := p.readFile("limits")
+	if err != nil {
+		return 0, 0, err
+	}
+	prefix := []byte(name)
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		// Names contain single spaces, and the name column is padded
+		// with several, so a prefix of a longer name doesn't match.
+		if !bytes.HasPrefix(line, prefix) || !bytes.HasPrefix(line[len(prefix):], []byte("  ")) {
+			continue
+		}
+		values := bytes.Fields(line[len(prefix):])
+		if len(values) < 2 {
+			return 0, 0, p.errorf("read limits", "malformed %q limit: %q", name, line)
+		}
+		if soft, err = parseLimit(values[0]); err != nil {
+			return 0, 0, p.errorf("read limits", "malformed %q soft limit: %v", name, err)
+		}
+		if hard, err = parseLimit(values[1]); err != nil {
+			return 0, 0, p.errorf("read limits", "malformed %q hard limit: %v", name, err)
+		}
+		return soft, hard, nil
+	}
+	return 0, 0, p.errorf("read limits", "no %q limit", name)
+}
+
+// parseLimit parses one value from /proc/<pid>/limits.
+func parseLimit(value []byte) (uint64, error) {
+	if string(value) == "unlimited" {
+		return Unlimited, nil
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const fixtureLimits = `Limit                     Soft Limit           Hard Limit           Units     
+Max cpu time              unlimited            unlimited            seconds   
+Max file size             unlimited            unlimited            bytes     
+Max processes             63432                63432                processes 
+Max open files            1024                 524288               files     
+Max locked memory         8388608              8388608              bytes     
+Max realtime timeout      unlimited            unlimited            us        
+`
+
+func TestLimit(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("42/limits", fixtureLimits)
+	p := &Process{Pid: 42}
+
+	cases := []struct {
+		name       string
+		soft, hard uint64
+	}{
+		{"Max open files", 1024, 524288},
+		{"Max cpu time", Unlimited, Unlimited},
+		{"Max processes", 63432, 63432},
+	}
+	for _, tc := range cases {
+		soft, hard, err := p.Limit(tc.name)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if soft != tc.soft || hard != tc.hard {
+			t.Errorf("%q: expected %d/%d, got %d/%d", tc.name, tc.soft, tc.hard, soft, hard)
+		}
+	}
+
+	// Neither a missing limit nor a prefix of one should match.
+	for _, name := range []string{"Max open fds", "Max open", "Max"} {
+		if _, _, err := p.Limit(name); err == nil || !strings.Contains(err.Error(), "no ") {
+			t.Errorf("%q: expected a not-found error, got %v", name, err)
+		}
+	}
+	if _, _, err := (&Process{Pid: 43}).Limit("Max open files"); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// ProcessName pairs a PID with the name of its process.
+type ProcessName struct {
+	PID  int32
+	Name string
+}
+
+// ListProcesses returns the PID and name of every process, sorted by PID.
+// Processes whose name can't be read are left out.  This is meant to help
+// find the exact name to signal.
+func ListProcesses(ctx context.Context) ([]ProcessName, error) {
+	procs, err := Processes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ProcessName, 0, len(procs))
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil {
+			continue
+		}
+		list = append(list, ProcessName{PID: p.Pid, Name: name})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+	return list, nil
+}
+
+// TopOldest returns the n processes which have been running longest, oldest
+// first, or all of them if there are fewer.  Processes whose start time
+// can't be read are left out.
+func TopOldest(ctx context.Context, n int) ([]*Process, error) {
+	return topByAge(ctx, n, false)
+}
+
+// TopNewest returns the n processes which started most recently, newest
+// first, as TopOldest does.
+func TopNewest(ctx context.Context, n int) ([]*Process, error) {
+	return topByAge(ctx, n, true)
+}
+
+// topByAge returns the first n processes as sorted by byAge.  Start times are
+// read by Scan's workers, and are cached in the processes returned.
+func topByAge(ctx context.Context, n int, newestFirst bool) ([]*Process, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var mu sync.Mutex
+	var readable []*Process
+	_, err := Scan(ctx, 0, func(p *Process) error {
+		if _, err := p.CreateTime(); err != nil {
+			return err
+		}
+		mu.Lock()
+		readable = append(readable, p)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sorted := byAge(readable, newestFirst)
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted, nil
+}
+
+// StateCounts returns how many processes are in each state, keyed by the
+// single-letter state as returned by Status, e.g. "R" or "D".  A jump in
+// processes in uninterruptible sleep (D) is a good sign of a struggling
+// host.  Processes whose state can't be read are left out.
+func StateCounts(ctx context.Context) (map[string]int, error) {
+	counts := map[string]int{}
+	err := ForEachProcess(ctx, func(p *Process) bool {
+		if state, err := p.Status(); err == nil {
+			counts[state]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestListProcesses(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 300, name: "nginx"})
+	f.add(fakeProc{pid: 1, name: "pause"})
+	f.add(fakeProc{pid: 20, name: "git-sync"})
+	// A process whose status is gone is skipped.
+	f.write("25/cmdline", "")
+
+	list, err := ListProcesses(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []ProcessName{
+		{PID: 1, Name: "pause"},
+		{PID: 20, Name: "git-sync"},
+		{PID: 300, Name: "nginx"},
+	}
+	if !reflect.DeepEqual(list, exp) {
+		t.Errorf("expected %v, got %v", exp, list)
+	}
+}
+
+func TestStateCounts(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "init", state: "S"})
+	f.add(fakeProc{pid: 2, name: "worker", state: "R"})
+	f.add(fakeProc{pid: 3, name: "worker", state: "D"})
+	f.add(fakeProc{pid: 4, name: "worker", state: "D"})
+	f.add(fakeProc{pid: 5, name: "dead", state: "Z"})
+	f.add(fakeProc{pid: 6, name: "stopped", state: "T"})
+	f.write("7/cmdline", "")
+
+	counts, err := StateCounts(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := map[string]int{"S": 1, "R": 1, "D": 2, "Z": 1, "T": 1}
+	if !reflect.DeepEqual(counts, exp) {
+		t.Errorf("expected %v, got %v", exp, counts)
+	}
+}
+
+func TestTopByAge(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "init", start: 1})
+	f.add(fakeProc{pid: 300, name: "nginx", start: 5000})
+	f.add(fakeProc{pid: 20, name: "git-sync", start: 400})
+	f.add(fakeProc{pid: 21, name: "sidecar", start: 400})
+	f.add(fakeProc{pid: 900, name: "shell", start: 90000})
+	// A process whose start time can't be read is skipped.
+	f.write("50/stat", "50 (broken\n")
+	pids := func(procs []*Process) []int32 {
+		var pids []int32
+		for _, p := range procs {
+			pids = append(pids, p.Pid)
+		}
+		return pids
+	}
+	ctx := context.Background()
+
+	cases := []struct {
+		top    func(context.Context, int) ([]*Process, error)
+		n      int
+		expect []int32
+	}{
+		{TopOldest, 3, []int32{1, 20, 21}},
+		{TopOldest, 10, []int32{1, 20, 21, 300, 900}},
+		{TopNewest, 2, []int32{900, 300}},
+		{TopNewest, 5, []int32{900, 300, 20, 21, 1}},
+		{TopOldest, 0, nil},
+		{TopNewest, -1, nil},
+	}
+	for i, tc := range cases {
+		procs, err := tc.top(ctx, tc.n)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if got := pids(procs); !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%d: n=%d: expected %v, got %v", i, tc.n, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpListen is the state of a listening socket in /proc/net/tcp, from
+// include/net/tcp_states.h.
+const tcpListen = "0A"
+
+// ProcessesListeningOn returns the processes which have a TCP socket
+// listening on port, on any address, IPv4 or IPv6, in increasing PID order.
+// This is for checking that a server has bound its port again after being
+// signaled to reload.  A socket inherited by a server's workers is reported
+// for each of them.
+//
+// Sockets are found in the net/tcp and net/tcp6 files of the proc
+// filesystem, so only those in its network namespace are seen.  They are
+// traced back to processes through their file descriptors, which can only be
+// read for processes we may inspect, so others are missed.
+func ProcessesListeningOn(port int) ([]*Process, error) {
+	return ProcFS{}.ProcessesListeningOn(port)
+}
+
+// ProcessesListeningOn is the package-level ProcessesListeningOn, for the
+// processes in this proc filesystem.
+func (fs ProcFS) ProcessesListeningOn(port int) ([]*Process, error) {
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	inodes := map[uint64]bool{}
+	for _, name := range []string{"tcp", "tcp6"} {
+		data, err := ioutil.ReadFile(fs.path("net", name))
+		if os.IsNotExist(err) && name == "tcp6" {
+			// IPv6 is disabled.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := parseListeners(data, port, inodes); err != nil {
+			return nil, fmt.Errorf("failed to parse net/%s: %v", name, err)
+		}
+	}
+	if len(inodes) == 0 {
+		return nil, nil
+	}
+	return fs.processesMatching(context.Background(), func(p *Process) (bool, error) {
+		return p.hasSocket(inodes)
+	})
+}
+
+// parseListeners adds to inodes the inode of each socket in data, the
+// contents of /proc/net/tcp or /proc/net/tcp6, which is listening on port.
+func parseListeners(data []byte, port int, inodes map[uint64]bool) error {
+	lines := bytes.Split(data, []byte("\n"))
+	// The first line is a header.
+	for i, line := range lines[1:] {
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 10 {
+			return fmt.Errorf("line %d: too few fields", i+2)
+		}
+		if fields[3] != tcpListen {
+			continue
+		}
+		// The local address is the IP address and port in hex, separated
+		// by a colon.
+		local := fields[1]
+		colon := strings.LastIndexByte(local, ':')
+		if colon < 0 {
+			return fmt.Errorf("line %d: malformed local address %q", i+2, local)
+		}
+		p, err := strconv.ParseUint(local[colon+1:], 16, 16)
+		if err != nil {
+			return fmt.Errorf("line %d: malformed local address %q: %v", i+2, local, err)
+		}
+		if int(p) != port {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: malformed inode %q: %v", i+2, fields[9], err)
+		}
+		inodes[inode] = true
+	}
+	return nil
+}
+
+// hasSocket returns true if the process has one of the sockets with these
+// inodes open.  File descriptors which are closed while they are checked are
+// skipped.
+func (p *Process) hasSocket(inodes map[uint64]bool) (bool, error) {
+	d, err := p.openFile("fd")
+	if err != nil {
+		return false, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return false, p.wrapErr("list fds", err)
+	}
+	for _, name := range names {
+		target, err := os.Readlink(p.path("fd", name))
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+			continue
+		}
+		inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+		if err == nil && inodes[inode] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
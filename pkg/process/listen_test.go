@@ -0,0 +1,123 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const fixtureTCPHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// fixtureTCP has sockets on port 8080 (1F90): one listening on any address
+// (inode 100), one connected (300), and one listening on port 9090 (2382,
+// inode 400).
+const fixtureTCP = fixtureTCPHeader +
+	"   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 100 1 0000000000000000 100 0 0 10 0\n" +
+	"   1: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000  1000        0 300 1 0000000000000000 20 4 30 10 -1\n" +
+	"   2: 0100007F:2382 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 400 1 0000000000000000 100 0 0 10 0\n"
+
+// fixtureTCP6 has a socket listening on port 8080 on any IPv6 address
+// (inode 200).
+const fixtureTCP6 = fixtureTCPHeader +
+	"   0: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 200 1 0000000000000000 100 0 0 10 0\n"
+
+func TestProcessesListeningOn(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("net/tcp", fixtureTCP)
+	f.write("net/tcp6", fixtureTCP6)
+	for pid, sockets := range map[int32][]string{
+		// A master listening on IPv4, and a worker which inherited it.
+		10: {"/dev/null", "socket:[100]"},
+		11: {"socket:[100]"},
+		// A server listening on IPv6.
+		12: {"pipe:[100]", "socket:[200]"},
+		// The client of the connection, and a server on another port.
+		13: {"socket:[300]"},
+		14: {"socket:[400]"},
+		15: {"socket:[999]"},
+	} {
+		f.add(fakeProc{pid: pid, name: "server"})
+		for fd, target := range sockets {
+			f.symlink(target, filepath.Join(strconv.Itoa(int(pid)), "fd", strconv.Itoa(fd)))
+		}
+	}
+	// A process whose file descriptors can't be read is skipped.
+	f.add(fakeProc{pid: 16, name: "hidden"})
+
+	pids := func(port int) []int32 {
+		t.Helper()
+		procs, err := ProcessesListeningOn(port)
+		if err != nil {
+			t.Fatalf("port %d: unexpected error: %v", port, err)
+		}
+		var pids []int32
+		for _, p := range procs {
+			pids = append(pids, p.Pid)
+		}
+		return pids
+	}
+	if got, exp := pids(8080), []int32{10, 11, 12}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("port 8080: expected %v, got %v", exp, got)
+	}
+	if got, exp := pids(9090), []int32{14}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("port 9090: expected %v, got %v", exp, got)
+	}
+	if got := pids(50000); got != nil {
+		t.Errorf("port 50000: expected nothing, got %v", got)
+	}
+
+	// Without IPv6.
+	if err := os.Remove(filepath.Join(f.root, "net", "tcp6")); err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := pids(8080), []int32{10, 11}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("port 8080 without IPv6: expected %v, got %v", exp, got)
+	}
+
+	for _, port := range []int{0, -1, 65536} {
+		if _, err := ProcessesListeningOn(port); err == nil {
+			t.Errorf("port %d: expected an error", port)
+		}
+	}
+	f.write("net/tcp", fixtureTCPHeader+"   0: 00000000:1F90 00000000:0000 0A\n")
+	if _, err := ProcessesListeningOn(8080); err == nil {
+		t.Errorf("expected an error for a malformed net/tcp")
+	}
+}
+
+func TestProcessesListeningOnSelf(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen: %v", err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	procs, err := ProcessesListeningOn(port)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(procs) != 1 || procs[0].Pid != int32(os.Getpid()) {
+		t.Errorf("expected only our own pid %d, got %v", os.Getpid(), procs)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MatchSpec describes which processes to match, declaratively.  Every field
+// which is set must match; unset fields match anything.
+type MatchSpec struct {
+	// Name, if set, is the exact name of the process, as Name returns.
+	Name string
+	// NamePrefix, if set, is a prefix of the process name.
+	NamePrefix string
+	// NameRegex, if set, must match the process name.  It is not anchored.
+	NameRegex *regexp.Regexp
+	// Exe, if set, is the exact path of the executable, as Exe returns.
+	Exe string
+	// CanonicalExe resolves symlinks in both Exe and the process's
+	// executable before comparing them, so that a binary reached through
+	// different links matches.  Links are resolved under the host's root,
+	// as for HostPath, since both paths are as the host sees them.  A
+	// deleted executable, or one which can't be resolved, is compared as
+	// is.
+	CanonicalExe bool
+	// CmdlineContains, if set, must appear in the command line, with the
+	// arguments joined by spaces.
+	CmdlineContains string
+	// MinAge, if set, is how long ago the process must have started.
+	MinAge time.Duration
+	// Uid, if set, is the real user ID the process must run as.
+	Uid *uint32
+	// CgroupPrefix, if set, is a cgroup the process must be in, or be
+	// nested under.
+	CgroupPrefix string
+	// IgnoreUnreadable makes a field whose value can't be read, for
+	// example the Exe of another user's process, count as matching rather
+	// than being an error.  This can match more processes than intended,
+	// so use it with other fields which can be read.  A process which has
+	// exited is always an error.
+	IgnoreUnreadable bool
+}
+
+// empty returns true if the spec has no fields which restrict matches.
+func (s *MatchSpec) empty() bool {
+	return s.Name == "" && s.NamePrefix == "" && s.NameRegex == nil && s.Exe == "" &&
+		s.CmdlineContains == "" && s.MinAge == 0 && s.Uid == nil && s.CgroupPrefix == ""
+}
+
+// Matches returns true if the process matches every field set in spec.  The
+// cheapest fields are checked first, and checking stops at the first which
+// doesn't match.
+func (p *Process) Matches(spec MatchSpec) (bool, error) {
+	var checks []func() (bool, error)
+	if spec.Name != "" || spec.NamePrefix != "" || spec.NameRegex != nil {
+		checks = append(checks, func() (bool, error) {
+			name, err := p.Name()
+			if err != nil {
+				return false, err
+			}
+			return (spec.Name == "" || name == spec.Name) &&
+				strings.HasPrefix(name, spec.NamePrefix) &&
+				(spec.NameRegex == nil || spec.NameRegex.MatchString(name)), nil
+		})
+	}
+	if spec.Uid != nil {
+		checks = append(checks, func() (bool, error) {
+			uid, _, _, err := p.Uids()
+			return err == nil && uid == *spec.Uid, err
+		})
+	}
+	if spec.MinAge > 0 {
+		checks = append(checks, func() (bool, error) {
+			age, err := p.Age()
+			return err == nil && age >= spec.MinAge, err
+		})
+	}
+	if spec.Exe != "" {
+		want := spec.Exe
+		if spec.CanonicalExe {
+			want = canonicalPath(p.fs.hostRoot(), want)
+		}
+		checks = append(checks, func() (bool, error) {
+			exe, err := p.Exe()
+			if err != nil {
+				return false, err
+			}
+			if spec.CanonicalExe {
+				exe = canonicalPath(p.fs.hostRoot(), exe)
+			}
+			return exe == want, nil
+		})
+	}
+	if spec.CgroupPrefix != "" {
+		checks = append(checks, func() (bool, error) {
+			path, err := p.Cgroup()
+			return err == nil && inCgroup(path, spec.CgroupPrefix), err
+		})
+	}
+	if spec.CmdlineContains != "" {
+		checks = append(checks, func() (bool, error) {
+			cmdline, err := p.Cmdline()
+			return err == nil && strings.Contains(cmdline, spec.CmdlineContains), err
+		})
+	}
+	for _, check := range checks {
+		ok, err := check()
+		if err != nil {
+			if spec.IgnoreUnreadable && !errors.Is(err, ErrProcessNotFound) {
+				continue
+			}
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SignalProcsMatching sends sig to every process which matches spec, as
+// Matches decides, and returns the number of processes signaled.  Processes
+// which can't be checked are skipped.  A spec with no fields set is an
+// error, rather than a way to signal everything.
+func SignalProcsMatching(spec MatchSpec, sig Signal) (int, error) {
+	if spec.empty() {
+		return 0, fmt.Errorf("match spec has no fields set")
+	}
+	if err := spec.validate(); err != nil {
+		return 0, err
+	}
+	return SignalProcsBy(func(p *Process) (bool, error) {
+		return p.Matches(spec)
+	}, sig)
+}
+
+// CountMatches returns the number of processes which match spec, as Matches
+// decides, without signaling any, e.g. for a health check that exactly one
+// is running.  Processes which can't be checked aren't counted.  A spec with
+// no fields set counts every process.
+func CountMatches(spec MatchSpec) (int, error) {
+	if err := spec.validate(); err != nil {
+		return 0, err
+	}
+	procs, err := ProcFS{}.processesMatching(context.Background(), func(p *Process) (bool, error) {
+		return p.Matches(spec)
+	})
+	return len(procs), err
+}
+
+// validate returns an error if s can never match.
+func (s *MatchSpec) validate() error {
+	if s.CgroupPrefix != "" && !strings.HasPrefix(s.CgroupPrefix, "/") {
+		return fmt.Errorf("invalid cgroup prefix %q: must be absolute", s.CgroupPrefix)
+	}
+	return nil
+}
+
+// deletedSuffix is what the kernel appends to the exe link of a process whose
+// executable has been deleted.
+const deletedSuffix = " (deleted)"
+
+// canonicalPath returns path with symlinks resolved under root, or path
+// itself if it can't be resolved, including because it has been deleted.
+func canonicalPath(root, path string) string {
+	if strings.HasSuffix(path, deletedSuffix) {
+		return path
+	}
+	resolved, err := resolveIn(root, path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	clock := useFakeClock()
+	defer clock.restore()
+	// The fixture boots at fixtureBootTime, and start is in ticks since.
+	clock.Advance(time.Unix(int64(fixtureBootTime)+1000, 0).Sub(clock.Now()))
+	uid := func(id uint32) *uint32 { return &id }
+
+	f.add(fakeProc{pid: 10, name: "nginx", start: 100, cmdline: []string{"/usr/sbin/nginx", "-g", "daemon off;"}})
+	f.add(fakeProc{pid: 11, name: "nginx-exporter", start: 90000, cmdline: []string{"/bin/nginx-exporter", "--port=9113"}})
+	f.add(fakeProc{pid: 12, name: "python3", start: 100, cmdline: []string{"python3", "app.py"}})
+	for pid, exe := range map[int32]string{10: "/usr/sbin/nginx", 11: "/bin/nginx-exporter"} {
+		if err := os.Symlink(exe, filepath.Join(f.root, strconv.Itoa(int(pid)), "exe")); err != nil {
+			t.Fatalf("failed to create exe link: %v", err)
+		}
+	}
+	// Not a link, so it can't be read, as with another user's process.
+	f.write("12/exe", "")
+	f.write("10/cgroup", "0::/kubepods/pod1/web\n")
+	f.write("11/cgroup", "0::/kubepods/pod1/exporter\n")
+	f.write("12/cgroup", "0::/kubepods/pod2/app\n")
+
+	cases := []struct {
+		name string
+		spec MatchSpec
+		exp  []int32
+	}{
+		{"empty", MatchSpec{}, []int32{10, 11, 12}},
+		{"name", MatchSpec{Name: "nginx"}, []int32{10}},
+		{"prefix", MatchSpec{NamePrefix: "nginx"}, []int32{10, 11}},
+		{"regex", MatchSpec{NameRegex: regexp.MustCompile(`^(nginx|python)\d*$`)}, []int32{10, 12}},
+		{"prefix and regex", MatchSpec{NamePrefix: "nginx", NameRegex: regexp.MustCompile(`exporter`)}, []int32{11}},
+		{"name and prefix disagree", MatchSpec{Name: "nginx", NamePrefix: "python"}, nil},
+		{"exe", MatchSpec{Exe: "/usr/sbin/nginx"}, []int32{10}},
+		{"cmdline", MatchSpec{CmdlineContains: "daemon off"}, []int32{10}},
+		{"cmdline and prefix", MatchSpec{NamePrefix: "nginx", CmdlineContains: "--port"}, []int32{11}},
+		// 11 started 900 seconds after boot, the others 1.
+		{"min age", MatchSpec{MinAge: 500 * time.Second}, []int32{10, 12}},
+		{"min age and prefix", MatchSpec{NamePrefix: "nginx", MinAge: 500 * time.Second}, []int32{10}},
+		{"uid", MatchSpec{Uid: uid(1000)}, []int32{10, 11, 12}},
+		{"other uid", MatchSpec{Uid: uid(0)}, nil},
+		{"cgroup", MatchSpec{CgroupPrefix: "/kubepods/pod1"}, []int32{10, 11}},
+		{"cgroup and exe", MatchSpec{CgroupPrefix: "/kubepods/pod1", Exe: "/bin/nginx-exporter"}, []int32{11}},
+		{"everything", MatchSpec{
+			Name:            "nginx",
+			NamePrefix:      "ng",
+			NameRegex:       regexp.MustCompile(`x$`),
+			Exe:             "/usr/sbin/nginx",
+			CmdlineContains: "-g",
+			MinAge:          time.Second,
+			Uid:             uid(1000),
+			CgroupPrefix:    "/kubepods",
+		}, []int32{10}},
+	}
+	for _, tc := range cases {
+		var got []int32
+		for _, pid := range []int32{10, 11, 12} {
+			ok, err := (&Process{Pid: pid}).Matches(tc.spec)
+			if err != nil {
+				// 12's exe can't be read, which is fine if it is
+				// ruled out first.
+				if pid == 12 && tc.spec.Exe != "" {
+					continue
+				}
+				t.Errorf("%s: pid %d: unexpected error: %v", tc.name, pid, err)
+				continue
+			}
+			if ok {
+				got = append(got, pid)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.exp, got)
+		}
+	}
+
+	// 12's exe can't be read, so it can't be ruled in or out by Exe.
+	p := &Process{Pid: 12}
+	if _, err := p.Matches(MatchSpec{Exe: "/usr/bin/python3"}); err == nil {
+		t.Errorf("expected an error for an unreadable exe")
+	}
+	// The name rules it out before the exe is read.
+	if ok, err := p.Matches(MatchSpec{Name: "nginx", Exe: "/usr/sbin/nginx"}); err != nil || ok {
+		t.Errorf("expected a short-circuited non-match, got %v: %v", ok, err)
+	}
+	if ok, err := p.Matches(MatchSpec{Name: "python3", Exe: "/usr/bin/python3", IgnoreUnreadable: true}); err != nil || !ok {
+		t.Errorf("expected the unreadable exe to be ignored, got %v: %v", ok, err)
+	}
+	if _, err := (&Process{Pid: 13}).Matches(MatchSpec{Name: "nginx", IgnoreUnreadable: true}); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestMatchesCanonicalExe(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	dir, remove := tempDir(t)
+	defer remove()
+	base, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("failed to resolve temp dir: %v", err)
+	}
+	bin := filepath.Join(base, "opt", "app", "bin", "server")
+	if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("failed to write binary: %v", err)
+	}
+	// The same binary, through a linked directory and a linked file.
+	if err := os.Symlink(filepath.Join(base, "opt", "app"), filepath.Join(base, "current")); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	linked := filepath.Join(base, "current", "bin", "server")
+	if err := os.Symlink(bin, filepath.Join(base, "server")); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	exes := map[int32]string{
+		10: bin,
+		11: linked,
+		12: bin + deletedSuffix,
+		13: filepath.Join(base, "missing"),
+	}
+	for pid, exe := range exes {
+		f.add(fakeProc{pid: pid, name: "server"})
+		if err := os.Symlink(exe, filepath.Join(f.root, strconv.Itoa(int(pid)), "exe")); err != nil {
+			t.Fatalf("failed to create exe link: %v", err)
+		}
+	}
+
+	cases := []struct {
+		spec MatchSpec
+		exp  []int32
+	}{
+		{MatchSpec{Exe: linked}, []int32{11}},
+		{MatchSpec{Exe: linked, CanonicalExe: true}, []int32{10, 11}},
+		{MatchSpec{Exe: filepath.Join(base, "server"), CanonicalExe: true}, []int32{10, 11}},
+		// A deleted or missing binary is compared as is.
+		{MatchSpec{Exe: bin + deletedSuffix, CanonicalExe: true}, []int32{12}},
+		{MatchSpec{Exe: filepath.Join(base, "missing"), CanonicalExe: true}, []int32{13}},
+	}
+	for _, tc := range cases {
+		var got []int32
+		for _, pid := range []int32{10, 11, 12, 13} {
+			ok, err := (&Process{Pid: pid}).Matches(tc.spec)
+			if err != nil {
+				t.Errorf("%+v: pid %d: unexpected error: %v", tc.spec, pid, err)
+			}
+			if ok {
+				got = append(got, pid)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%+v: expected %v, got %v", tc.spec, tc.exp, got)
+		}
+	}
+}
+
+func TestSignalProcsMatching(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx", cmdline: []string{"nginx", "-c", "/etc/a.conf"}})
+	f.add(fakeProc{pid: 11, name: "nginx", cmdline: []string{"nginx", "-c", "/etc/b.conf"}})
+	f.add(fakeProc{pid: 12, name: "other", cmdline: []string{"other", "-c", "/etc/b.conf"}})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	n, err := SignalProcsMatching(MatchSpec{Name: "nginx", CmdlineContains: "b.conf"}, Signal(syscall.SIGHUP))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{11}; n != 1 || !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v, got %d: %v", exp, n, rec.signaled())
+	}
+
+	for _, spec := range []MatchSpec{{}, {IgnoreUnreadable: true}, {CgroupPrefix: "kubepods"}} {
+		if _, err := SignalProcsMatching(spec, Signal(syscall.SIGHUP)); err == nil {
+			t.Errorf("%+v: expected an error", spec)
+		}
+	}
+	if len(rec.signaled()) != 1 {
+		t.Errorf("expected nothing more signaled, got %v", rec.signaled())
+	}
+}
+
+func TestCountMatches(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "master", cmdline: []string{"master", "--role=primary"}})
+	f.add(fakeProc{pid: 11, name: "worker", cmdline: []string{"worker", "--id=1"}})
+	f.add(fakeProc{pid: 12, name: "worker", cmdline: []string{"worker", "--id=2"}})
+	f.add(fakeProc{pid: 13, name: "worker", cmdline: []string{"worker", "--id=3"}})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	cases := []struct {
+		spec MatchSpec
+		n    int
+	}{
+		{MatchSpec{Name: "standby"}, 0},
+		{MatchSpec{Name: "master"}, 1},
+		{MatchSpec{Name: "worker"}, 3},
+		{MatchSpec{Name: "worker", CmdlineContains: "--id=2"}, 1},
+		{MatchSpec{}, 4},
+	}
+	for _, tc := range cases {
+		n, err := CountMatches(tc.spec)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if n != tc.n {
+			t.Errorf("%+v: expected %d, got %d", tc.spec, tc.n, n)
+		}
+	}
+	if _, err := CountMatches(MatchSpec{CgroupPrefix: "kubepods"}); err == nil {
+		t.Errorf("expected an error for a relative cgroup prefix")
+	}
+	if len(rec.signaled()) != 0 {
+		t.Errorf("expected nothing signaled, got %v", rec.signaled())
+	}
+}
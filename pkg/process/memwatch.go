@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryGrowth is how fast the resident memory of a process is growing, as
+// reported by a MemoryWatcher.
+type MemoryGrowth struct {
+	// RSS is the latest resident set size, in bytes.
+	RSS uint64
+	// Rate is the growth in bytes per second, which is negative if memory
+	// is being freed.
+	Rate float64
+	// Span is the time the rate is measured over, from the oldest sample
+	// in the window to the latest.
+	Span time.Duration
+}
+
+// MemoryWatcher samples the resident memory of a process on a schedule, and
+// calls Alert when it grows faster than Threshold, for spotting a leak, e.g.
+// in a server which is reloaded after every sync.  It stops by itself when
+// the process exits.  It is safe for concurrent use.
+type MemoryWatcher struct {
+	// Process is the process to watch.
+	Process *Process
+	// Interval is the time between samples.
+	Interval time.Duration
+	// Window is how far back the growth rate is measured, which smooths
+	// out the short-lived growth of, say, handling a burst of requests.
+	// It must be at least Interval.
+	Window time.Duration
+	// Threshold is the growth rate, in bytes per second, above which Alert
+	// is called.
+	Threshold float64
+	// Alert is called, from the watcher's goroutine, when the growth rate
+	// goes above Threshold.  It isn't called again until the rate has gone
+	// back down to Threshold or below, and then above it again.
+	Alert func(MemoryGrowth)
+	// Clock, if set, is used instead of the real clock to time samples.
+	Clock Clock
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	// done is closed when the background goroutine has exited.
+	done chan struct{}
+}
+
+// rssSample is the resident set size of a process at a point in time.
+type rssSample struct {
+	at  time.Time
+	rss uint64
+}
+
+// Start begins sampling in a background goroutine, which runs until ctx is
+// done, Stop is called, or the process exits or is replaced by another with
+// its PID.  The first sample is taken straight away.  Samples which can't be
+// taken for other reasons are skipped.  A MemoryWatcher may only be started
+// once.
+func (w *MemoryWatcher) Start(ctx context.Context) error {
+	if w.Process == nil {
+		return errors.New("no process to watch")
+	}
+	if w.Interval <= 0 {
+		return fmt.Errorf("invalid interval %v", w.Interval)
+	}
+	if w.Window < w.Interval {
+		return fmt.Errorf("invalid window %v: must be at least the interval, %v", w.Window, w.Interval)
+	}
+	if w.Alert == nil {
+		return errors.New("no alert callback")
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.done != nil {
+		return errors.New("memory watcher already started")
+	}
+	// Pin the process's identity, so that Replaced can tell if its PID is
+	// reused.
+	if _, err := w.Process.CreateTime(); err != nil {
+		return err
+	}
+	c := clockOr(w.Clock)
+	ctx, w.cancel = context.WithCancel(ctx)
+	done := make(chan struct{})
+	w.done = done
+	go func() {
+		defer close(done)
+		w.run(ctx, c)
+	}()
+	return nil
+}
+
+// run samples the process until ctx is done or the process is gone.
+func (w *MemoryWatcher) run(ctx context.Context, c Clock) {
+	var samples []rssSample
+	alerting := false
+	for {
+		rss, err := w.Process.RssBytes()
+		if gone, _ := w.Process.Replaced(); gone {
+			return
+		}
+		if err == nil {
+			now := c.Now()
+			samples = append(samples, rssSample{now, rss})
+			cutoff := now.Add(-w.Window)
+			first := 0
+			for samples[first].at.Before(cutoff) {
+				first++
+			}
+			samples = samples[first:]
+
+			if span := now.Sub(samples[0].at); span > 0 {
+				rate := (float64(rss) - float64(samples[0].rss)) / span.Seconds()
+				exceeded := rate > w.Threshold
+				if exceeded && !alerting {
+					w.Alert(MemoryGrowth{RSS: rss, Rate: rate, Span: span})
+				}
+				alerting = exceeded
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.After(w.Interval):
+		}
+	}
+}
+
+// Done returns a channel which is closed once the watcher has stopped,
+// whether because of Stop, its context, or the process exiting.  It is nil
+// if the watcher hasn't been started.
+func (w *MemoryWatcher) Done() <-chan struct{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.done
+}
+
+// Stop halts sampling, and returns once the background goroutine has exited.
+// It is safe to call Stop more than once, including concurrently, and on a
+// watcher which was never started or has already stopped by itself.
+func (w *MemoryWatcher) Stop() {
+	w.mutex.Lock()
+	cancel, done := w.cancel, w.done
+	w.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryWatcher(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "server"})
+	status, err := (&Process{Pid: 42}).ReadProcFile("status")
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	setRSS := func(kb int) {
+		f.write("42/status", string(status)+"VmRSS:\t"+strconv.Itoa(kb)+" kB\n")
+	}
+	setRSS(1000)
+
+	var mu sync.Mutex
+	var alerts []MemoryGrowth
+	clock := newFakeClock()
+	w := &MemoryWatcher{
+		Process:   &Process{Pid: 42},
+		Interval:  10 * time.Second,
+		Window:    time.Minute,
+		Threshold: 1024,
+		Alert: func(g MemoryGrowth) {
+			mu.Lock()
+			alerts = append(alerts, g)
+			mu.Unlock()
+		},
+		Clock: clock,
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Stop()
+	if err := w.Start(context.Background()); err == nil {
+		t.Errorf("expected an error starting twice")
+	}
+	clock.BlockUntil(1)
+
+	// sample moves the clock on to the next sample, of kb kilobytes, and
+	// returns the alerts so far.
+	sample := func(kb int) []MemoryGrowth {
+		setRSS(kb)
+		clock.Advance(10 * time.Second)
+		clock.BlockUntil(1)
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]MemoryGrowth(nil), alerts...)
+	}
+
+	// Slow growth, of 512 bytes per second, then faster, until the rate
+	// over the last minute passes the threshold.
+	for _, kb := range []int{1005, 1010, 1015, 1035} {
+		if got := sample(kb); len(got) != 0 {
+			t.Fatalf("%d kB: expected no alerts, got %+v", kb, got)
+		}
+	}
+	got := sample(1055)
+	if exp := (MemoryGrowth{RSS: 1055 << 10, Rate: 55 * 1024 / 50.0, Span: 50 * time.Second}); len(got) != 1 || got[0] != exp {
+		t.Fatalf("expected an alert of %+v, got %+v", exp, got)
+	}
+	// The rate staying high doesn't alert again, and once memory stops
+	// growing, it drops out of the window.
+	for i := 0; i < 10; i++ {
+		if got := sample(1075); len(got) != 1 {
+			t.Fatalf("sample %d: expected no more alerts, got %+v", i, got)
+		}
+	}
+	// Growing fast again does.
+	if got := sample(1200); len(got) != 2 || got[1].RSS != 1200<<10 {
+		t.Fatalf("expected a second alert, got %+v", got)
+	}
+
+	// The process exiting stops the watcher.
+	if err := os.RemoveAll(filepath.Join(f.root, "42")); err != nil {
+		t.Fatalf("failed to remove pid 42: %v", err)
+	}
+	clock.Advance(10 * time.Second)
+	select {
+	case <-w.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the watcher to stop when the process exited")
+	}
+	w.Stop()
+}
+
+func TestMemoryWatcherInvalid(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "server"})
+	alert := func(MemoryGrowth) {}
+	p := &Process{Pid: 42}
+	for _, w := range []*MemoryWatcher{
+		{Interval: time.Second, Window: time.Minute, Alert: alert},
+		{Process: p, Window: time.Minute, Alert: alert},
+		{Process: p, Interval: time.Minute, Window: time.Second, Alert: alert},
+		{Process: p, Interval: time.Second, Window: time.Minute},
+		{Process: &Process{Pid: 43}, Interval: time.Second, Window: time.Minute, Alert: alert},
+	} {
+		if err := w.Start(context.Background()); err == nil {
+			t.Errorf("%+v: expected an error", w)
+			w.Stop()
+		}
+	}
+	// Stopping a watcher which never started is harmless.
+	(&MemoryWatcher{}).Stop()
+	if (&MemoryWatcher{}).Done() != nil {
+		t.Errorf("expected no done channel before starting")
+	}
+}
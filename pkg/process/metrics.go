@@ -0,0 +1,48 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// scanSkippedHook holds the func() set by SetScanSkippedHook.
+var scanSkippedHook atomic.Value
+
+// SetScanSkippedHook sets fn to be called whenever a scan of the proc
+// filesystem skips a process because it exited while being inspected, for
+// example to count skips in a metric.  High counts mean a lot of process
+// churn, which may explain a process not being found.  fn may be called from
+// several goroutines at once, and must be quick.  A nil fn removes the hook.
+func SetScanSkippedHook(fn func()) {
+	if fn == nil {
+		fn = func() {}
+	}
+	scanSkippedHook.Store(fn)
+}
+
+// countSkip calls the hook set by SetScanSkippedHook, if err means that a
+// process exited while being inspected.
+func countSkip(err error) {
+	if !errors.Is(err, ErrProcessNotFound) {
+		return
+	}
+	if fn, ok := scanSkippedHook.Load().(func()); ok {
+		fn()
+	}
+}
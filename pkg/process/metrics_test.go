@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// countSkips installs a scan-skipped hook, and returns the count it keeps.
+// Callers remove the hook with SetScanSkippedHook(nil).
+func countSkips() *int32 {
+	var n int32
+	SetScanSkippedHook(func() { atomic.AddInt32(&n, 1) })
+	return &n
+}
+
+func TestScanSkippedHook(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "app"})
+	f.add(fakeProc{pid: 11, name: "app"})
+	f.add(fakeProc{pid: 12, name: "app"})
+	defer recordSignals(t).restore()
+	skips := countSkips()
+	defer SetScanSkippedHook(nil)
+
+	if n, err := SignalProcs("app", Signal(syscall.SIGHUP)); err != nil || n != 3 {
+		t.Fatalf("expected 3 processes signaled, got %d: %v", n, err)
+	}
+	if n := atomic.LoadInt32(skips); n != 0 {
+		t.Errorf("expected no skips, got %d", n)
+	}
+
+	// Pid 11 exits after the PIDs are listed, but before it is read.
+	if err := os.Remove(filepath.Join(f.root, "11", "status")); err != nil {
+		t.Fatalf("failed to remove status: %v", err)
+	}
+	if n, err := SignalProcs("app", Signal(syscall.SIGHUP)); err != nil || n != 2 {
+		t.Fatalf("expected 2 processes signaled, got %d: %v", n, err)
+	}
+	if n := atomic.LoadInt32(skips); n != 1 {
+		t.Errorf("expected 1 skip, got %d", n)
+	}
+	if _, err := SignalProcsDetailed(context.Background(), "app", Signal(syscall.SIGHUP), SignalOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(skips); n != 2 {
+		t.Errorf("expected 2 skips, got %d", n)
+	}
+
+	// Other failures aren't skips due to churn.
+	if n, err := SignalProcsBy(func(*Process) (bool, error) { return false, os.ErrPermission }, Signal(syscall.SIGHUP)); err != nil || n != 0 {
+		t.Fatalf("expected nothing signaled, got %d: %v", n, err)
+	}
+	if n := atomic.LoadInt32(skips); n != 2 {
+		t.Errorf("expected still 2 skips, got %d", n)
+	}
+
+	SetScanSkippedHook(nil)
+	if _, err := SignalProcs("app", Signal(syscall.SIGHUP)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(skips); n != 2 {
+		t.Errorf("expected no counting after removing the hook, got %d", n)
+	}
+}
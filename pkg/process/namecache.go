@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"sync"
+)
+
+// NameCache remembers the names of processes, for callers which look up the
+// same processes' names over and over, e.g. on every poll.  An entry is only
+// used while the process which has its PID has the same start time, so a
+// reused PID is never given the name of the process which had it before.
+// It is safe for concurrent use.  The zero value is an empty cache.
+//
+// Checking the start time still means one read of the proc filesystem per
+// lookup, but Name can take two, for names the kernel truncates.  Entries are
+// replaced when their PID is reused, so the cache holds at most one per PID;
+// use Forget to drop those of processes known to have exited.
+type NameCache struct {
+	mu      sync.Mutex
+	entries map[int32]nameEntry
+}
+
+// nameEntry is the name of the process with a particular start time.
+type nameEntry struct {
+	createTime int64
+	name       string
+}
+
+// Name returns the name of p, as Name does, from the cache if possible.
+func (c *NameCache) Name(p *Process) (string, error) {
+	start, err := p.readCreateTime()
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[p.Pid]
+	if ok && entry.createTime != start {
+		// The PID has been reused.
+		delete(c.entries, p.Pid)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		return entry.name, nil
+	}
+
+	name, err := p.Name()
+	if err != nil {
+		return "", err
+	}
+	// If the PID was reused while the name was read, the name might be of
+	// either process, so it isn't cached.
+	if now, err := p.readCreateTime(); err != nil || now != start {
+		return name, nil
+	}
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[int32]nameEntry{}
+	}
+	c.entries[p.Pid] = nameEntry{createTime: start, name: name}
+	c.mu.Unlock()
+	return name, nil
+}
+
+// Forget drops the cached name of the process with this PID, if any.
+func (c *NameCache) Forget(pid int32) {
+	c.mu.Lock()
+	delete(c.entries, pid)
+	c.mu.Unlock()
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestNameCache(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", start: 100})
+	var c NameCache
+	p := &Process{Pid: 42}
+
+	if name, err := c.Name(p); err != nil || name != "nginx" {
+		t.Fatalf("expected nginx, got %q: %v", name, err)
+	}
+	// Renamed in place, as by prctl, so the cached name is still used.
+	f.write("42/status", "Name:\trenamed\n")
+	if name, err := c.Name(p); err != nil || name != "nginx" {
+		t.Errorf("expected the cached name, got %q: %v", name, err)
+	}
+
+	// Reused by a process which started later.
+	f.add(fakeProc{pid: 42, name: "redis", start: 900})
+	if name, err := c.Name(p); err != nil || name != "redis" {
+		t.Errorf("expected the new process's name, got %q: %v", name, err)
+	}
+	if name, err := c.Name(&Process{Pid: 42}); err != nil || name != "redis" {
+		t.Errorf("expected the new name to be cached, got %q: %v", name, err)
+	}
+
+	c.Forget(42)
+	f.write("42/status", "Name:\trenamed\n")
+	if name, err := c.Name(p); err != nil || name != "renamed" {
+		t.Errorf("expected a fresh read after Forget, got %q: %v", name, err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(f.root, "42")); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := c.Name(p); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound once exited, got %q: %v", name, err)
+	}
+}
+
+func TestNameCacheConcurrent(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 20; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker", start: 100})
+	}
+	var c NameCache
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pid := int32(1); pid <= 20; pid++ {
+				if name, err := c.Name(&Process{Pid: pid}); err != nil || name != "worker" {
+					t.Errorf("pid %d: expected worker, got %q: %v", pid, name, err)
+				}
+				c.Forget(pid % 3)
+			}
+		}()
+	}
+	wg.Wait()
+}
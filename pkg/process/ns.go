@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NetNSID returns the ID of the network namespace the process is in, which
+// is the inode number of /proc/<pid>/ns/net.  Processes in the same pod share
+// one, so comparing IDs tells whether two processes see the same network.
+// Only the process's owner, or root, may read it.
+func (p *Process) NetNSID() (uint64, error) {
+	return p.namespaceID("net")
+}
+
+// namespaceID returns the ID of the process's namespace of the given kind,
+// from the link /proc/<pid>/ns/<kind>, which reads like "net:[4026531992]".
+func (p *Process) namespaceID(kind string) (uint64, error) {
+	op := "read ns/" + kind
+	link, err := os.Readlink(p.path("ns", kind))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return 0, p.wrapErr(op, err)
+	}
+	prefix := kind + ":["
+	if !strings.HasPrefix(link, prefix) || !strings.HasSuffix(link, "]") {
+		return 0, p.errorf(op, "malformed namespace %q", link)
+	}
+	id, err := strconv.ParseUint(link[len(prefix):len(link)-1], 10, 64)
+	if err != nil {
+		return 0, p.errorf(op, "malformed namespace %q: %v", link, err)
+	}
+	return id, nil
+}
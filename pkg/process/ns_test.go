@@ -0,0 +1,67 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetNSID(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid, link := range map[string]string{
+		"10": "net:[4026531992]",
+		"11": "net:[4026532281]",
+		"12": "net:[4026532281]",
+		"13": "mnt:[4026531840]",
+		"14": "net:[]",
+		"15": "net:4026531992",
+	} {
+		f.symlink(link, pid+"/ns/net")
+	}
+	// Exited, or a kernel too old to have the link.
+	if err := os.MkdirAll(filepath.Join(f.root, "16", "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for pid, exp := range map[int32]uint64{10: 4026531992, 11: 4026532281, 12: 4026532281} {
+		if id, err := (&Process{Pid: pid}).NetNSID(); err != nil || id != exp {
+			t.Errorf("pid %d: expected %d, got %d: %v", pid, exp, id, err)
+		}
+	}
+	for _, pid := range []int32{13, 14, 15} {
+		if id, err := (&Process{Pid: pid}).NetNSID(); err == nil {
+			t.Errorf("pid %d: expected an error, got %d", pid, id)
+		}
+	}
+	if _, err := (&Process{Pid: 16}).NetNSID(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestNetNSIDSelf(t *testing.T) {
+	self, err := Self().NetNSID()
+	if err != nil {
+		t.Skipf("can't read our own network namespace: %v", err)
+	}
+	if self == 0 {
+		t.Errorf("expected a non-zero ID for our own network namespace")
+	}
+}
@@ -0,0 +1,1167 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package process provides read-only access to the processes found in the
+// proc filesystem, plus helpers to signal them.  It is used by git-sync to
+// tell a server sharing its PID namespace that new content has been synced.
+//
+// The proc filesystem is read from /proc, or from the directory named by the
+// HOST_PROC environment variable if it is set.
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrProcessNotFound is returned, usually wrapped in a ProcessError, when a
+// process does not exist, including when it exits while being inspected.
+// Check for it with errors.Is.
+var ErrProcessNotFound = errors.New("process not found")
+
+// ProcessError is the error returned by a Process's accessors.  It says which
+// process and what was being done, and wraps the cause, so that, say,
+// errors.Is(err, ErrProcessNotFound) still works.
+type ProcessError struct {
+	Pid int32
+	// Op is what failed, e.g. "read status".
+	Op  string
+	Err error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("failed to %s for pid %d: %v", e.Op, e.Pid, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// wrapErr returns err as a *ProcessError for op on this process.
+func (p *Process) wrapErr(op string, err error) error {
+	return &ProcessError{Pid: p.Pid, Op: op, Err: err}
+}
+
+// errorf returns a *ProcessError for op on this process, whose cause is
+// formatted as by fmt.Errorf.
+func (p *Process) errorf(op, format string, args ...interface{}) error {
+	return p.wrapErr(op, fmt.Errorf(format, args...))
+}
+
+// maxCommLen is the length at which the kernel truncates a process name.
+const maxCommLen = 15
+
+// clockTicks is the value of USER_HZ, the unit of the times in
+// /proc/<pid>/stat.  This is 100 on every platform git-sync supports.
+const clockTicks = 100
+
+// Process is a handle to a single process, identified by its PID.
+type Process struct {
+	Pid int32
+	// Tgid is, for a thread listed by ProcessesWithOptions, the PID of the
+	// process it is a thread of, and the process is read from that one's
+	// task directory.  It is 0 for a process.
+	Tgid int32
+
+	// fs is the proc filesystem the process was found in.
+	fs ProcFS
+
+	// createTime caches the start time, once read.  It is accessed
+	// atomically.
+	createTime int64
+}
+
+// ProcFS is a proc filesystem mounted at a particular root, for callers
+// which need to look at more than one, e.g. of different PID namespaces.
+// The zero value uses HOST_PROC, or /proc if that is unset, as do the
+// package-level functions.
+type ProcFS struct {
+	root string
+	// batch is the number of entries Pids reads at a time, or all at once
+	// if it is not positive.
+	batch int
+	// clock is what its processes wait and measure by, or the real clock if
+	// it is nil.
+	clock Clock
+	// skipCheck is set if Pids doesn't check that root looks like a proc
+	// filesystem.
+	skipCheck bool
+	// maxCmdline is how much of a command line CmdlineSlice reads, or
+	// MaxCmdlineBytes if it is not positive.
+	maxCmdline int
+}
+
+// NewProcFS returns a ProcFS for the proc filesystem mounted at root.
+func NewProcFS(root string) ProcFS {
+	return ProcFS{root: root}
+}
+
+// WithReadDirBatchSize returns a copy of fs whose Pids, if n is positive,
+// reads the proc filesystem root n entries at a time, checking its context
+// between batches, rather than all at once.  On hosts with a great many
+// processes this bounds the memory used by each read, and lets a cancelled
+// scan stop sooner.
+func (fs ProcFS) WithReadDirBatchSize(n int) ProcFS {
+	fs.batch = n
+	return fs
+}
+
+// WithClock returns a copy of fs whose processes, and the helpers which wait
+// on them or measure their age, use c rather than the real clock.
+func (fs ProcFS) WithClock(c Clock) ProcFS {
+	fs.clock = c
+	return fs
+}
+
+// WithMaxCmdlineBytes returns a copy of fs whose processes' CmdlineSlice and
+// Cmdline, if n is positive, read at most n bytes of a command line rather
+// than MaxCmdlineBytes.
+func (fs ProcFS) WithMaxCmdlineBytes(n int) ProcFS {
+	fs.maxCmdline = n
+	return fs
+}
+
+// cmdlineLimit returns how much of a command line CmdlineSlice reads.
+func (fs ProcFS) cmdlineLimit() int {
+	if fs.maxCmdline > 0 {
+		return fs.maxCmdline
+	}
+	return MaxCmdlineBytes
+}
+
+// WithoutProcFSCheck returns a copy of fs whose Pids doesn't first check that
+// the root looks like a real proc filesystem, for unusual setups which lack
+// the files checked for.
+func (fs ProcFS) WithoutProcFSCheck() ProcFS {
+	fs.skipCheck = true
+	return fs
+}
+
+// path returns the path to the proc filesystem, joined with combineWith.
+func (fs ProcFS) path(combineWith ...string) string {
+	return filepath.Join(append([]string{fs.rootDir()}, combineWith...)...)
+}
+
+// rootDir returns the directory the proc filesystem is mounted at.
+func (fs ProcFS) rootDir() string {
+	if fs.root != "" {
+		return fs.root
+	}
+	if root := os.Getenv("HOST_PROC"); root != "" {
+		return root
+	}
+	return "/proc"
+}
+
+// NewProcess returns a Process for pid, or an error wrapping
+// ErrProcessNotFound if there is no such process.
+func NewProcess(pid int32) (*Process, error) {
+	return ProcFS{}.NewProcess(pid)
+}
+
+// NewProcess returns a Process for pid in this proc filesystem, or an error
+// wrapping ErrProcessNotFound if there is no such process.
+func (fs ProcFS) NewProcess(pid int32) (*Process, error) {
+	p := fs.process(pid)
+	if _, err := os.Stat(p.path()); err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return nil, p.wrapErr("stat", err)
+	}
+	return p, nil
+}
+
+// Self returns the calling process.
+func Self() *Process {
+	return ProcFS{}.process(int32(os.Getpid()))
+}
+
+// process returns a handle to pid in this proc filesystem, without checking
+// that it exists.
+func (fs ProcFS) process(pid int32) *Process {
+	return &Process{Pid: pid, fs: fs}
+}
+
+// Pids returns the PIDs of all processes, in increasing order.  The order is
+// not that of the directory, which is unspecified, so that everything built
+// on top of this is deterministic.
+func Pids(ctx context.Context) ([]int32, error) {
+	return ProcFS{}.Pids(ctx)
+}
+
+// Pids returns the PIDs of all processes in this proc filesystem, in
+// increasing order.
+func (fs ProcFS) Pids(ctx context.Context) ([]int32, error) {
+	root := fs.path()
+	if !fs.skipCheck {
+		if err := checkProcFS(root); err != nil {
+			return nil, err
+		}
+	}
+	pids, err := readPidsFromDir(ctx, root, fs.batch)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
+// checkProcFS returns an error if root doesn't contain the "self" or "stat"
+// entries every proc filesystem has, to catch HOST_PROC being set to some
+// other directory.
+func checkProcFS(root string) error {
+	for _, name := range []string{"self", "stat"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not look like a proc filesystem (no self or stat), check HOST_PROC", root)
+}
+
+// Processes returns all processes.
+func Processes(ctx context.Context) ([]*Process, error) {
+	return ProcFS{}.Processes(ctx)
+}
+
+// Processes returns all processes in this proc filesystem.
+func (fs ProcFS) Processes(ctx context.Context) ([]*Process, error) {
+	pids, err := fs.Pids(ctx)
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]*Process, 0, len(pids))
+	for _, pid := range pids {
+		procs = append(procs, fs.process(pid))
+	}
+	return procs, nil
+}
+
+// ProcessesOptions changes what ProcessesWithOptions returns.
+type ProcessesOptions struct {
+	// IncludeThreads adds, after each process, an entry for each of its
+	// threads other than the main one, whose Pid is the thread ID and whose
+	// Tgid is the PID of the process.  These can be inspected like any
+	// process, e.g. to find the thread of a server which is stuck.
+	//
+	// But signaling one signals its whole process, since kill(2) given a
+	// thread ID delivers to the thread group, so signaling every entry
+	// signals each process once per thread.  A thread's entry also
+	// remains valid only as long as the thread, which can be much shorter
+	// than its process.
+	IncludeThreads bool
+}
+
+// ProcessesWithOptions returns all processes, as Processes does, changed by
+// opts.
+func ProcessesWithOptions(ctx context.Context, opts ProcessesOptions) ([]*Process, error) {
+	return ProcFS{}.ProcessesWithOptions(ctx, opts)
+}
+
+// ProcessesWithOptions is the package-level ProcessesWithOptions, for the
+// processes in this proc filesystem.
+func (fs ProcFS) ProcessesWithOptions(ctx context.Context, opts ProcessesOptions) ([]*Process, error) {
+	procs, err := fs.Processes(ctx)
+	if err != nil || !opts.IncludeThreads {
+		return procs, err
+	}
+	withThreads := make([]*Process, 0, len(procs))
+	for _, p := range procs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		withThreads = append(withThreads, p)
+		tids, err := p.Threads()
+		if errors.Is(err, ErrProcessNotFound) {
+			// It has exited, and its threads with it.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, tid := range tids {
+			if tid != p.Pid {
+				withThreads = append(withThreads, &Process{Pid: tid, Tgid: p.Pid, fs: fs})
+			}
+		}
+	}
+	return withThreads, nil
+}
+
+// ForEachProcess calls fn for every process, in increasing PID order, until
+// fn returns false or ctx is done.
+func ForEachProcess(ctx context.Context, fn func(*Process) bool) error {
+	return ProcFS{}.ForEachProcess(ctx, fn)
+}
+
+// ForEachProcess calls fn for every process in this proc filesystem, as the
+// package-level ForEachProcess does.
+func (fs ProcFS) ForEachProcess(ctx context.Context, fn func(*Process) bool) error {
+	pids, err := fs.Pids(ctx)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(fs.process(pid)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readPidsFromDir returns the numeric entries of path, which is expected to
+// be the root of a proc filesystem, reading them as readPids does.
+func readPidsFromDir(ctx context.Context, path string, batch int) ([]int32, error) {
+	d, err := openWithRetry(ctx, os.Open, path, procDirOpenAttempts, procDirOpenBackoff)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	return readPids(ctx, d, batch)
+}
+
+// readPids returns the numeric entries of the directory d, reading batch
+// entries at a time and checking ctx in between, or all at once if batch is
+// not positive.
+func readPids(ctx context.Context, d *os.File, batch int) ([]int32, error) {
+	if batch <= 0 {
+		batch = -1
+	}
+	var pids []int32
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		names, err := d.Readdirnames(batch)
+		for _, name := range names {
+			pid, err := strconv.ParseInt(name, 10, 32)
+			if err != nil {
+				// Not a process, e.g. "self" or "meminfo".
+				continue
+			}
+			pids = append(pids, int32(pid))
+		}
+		if err == io.EOF || (err == nil && batch < 0) {
+			return pids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// These bound the retries when opening the proc filesystem root, which can
+// fail transiently while a container's namespaces are still being set up.
+const (
+	procDirOpenAttempts = 5
+	procDirOpenBackoff  = 10 * time.Millisecond
+)
+
+// openWithRetry calls open until it succeeds or has been tried maxAttempts
+// times, doubling the wait between attempts each time.  It returns the last
+// error, or ctx.Err() if ctx is done while waiting.
+func openWithRetry(ctx context.Context, open func(string) (*os.File, error), path string, maxAttempts int, backoff time.Duration) (*os.File, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var f *os.File
+		if f, err = open(path); err == nil {
+			return f, nil
+		}
+		if attempt >= maxAttempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-defaultClock.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// path returns the path to a file under this process's proc directory.
+func (p *Process) path(name ...string) string {
+	if p.Tgid != 0 {
+		return p.fs.path(append([]string{strconv.Itoa(int(p.Tgid)), "task", strconv.Itoa(int(p.Pid))}, name...)...)
+	}
+	pid := strconv.Itoa(int(p.Pid))
+	if len(name) == 1 {
+		// The usual case, which needn't build a slice.
+		return filepath.Join(p.fs.rootDir(), pid, name[0])
+	}
+	return p.fs.path(append([]string{pid}, name...)...)
+}
+
+// readFile reads a file under this process's proc directory.  A missing file
+// means the process is gone, and is reported as ErrProcessNotFound.
+func (p *Process) readFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(p.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return nil, p.wrapErr("read "+name, err)
+	}
+	return data, nil
+}
+
+// ReadProcFile reads the file rel under the process's proc directory, such
+// as "io", for fields this package doesn't parse.  A missing file is reported
+// as ErrProcessNotFound, as the process may have exited.  rel may not leave
+// the process directory, whether by "..", by being absolute, or by going
+// through a symlink like cwd or root.
+func (p *Process) ReadProcFile(rel string) ([]byte, error) {
+	clean := filepath.Clean(rel)
+	if rel == "" || filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return nil, p.errorf("read "+rel, "invalid proc file")
+	}
+	parts := strings.Split(clean, string(filepath.Separator))
+	for i := range parts {
+		fi, err := os.Lstat(p.path(parts[:i+1]...))
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		if err != nil {
+			return nil, p.wrapErr("read "+rel, err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil, p.errorf("read "+rel, "invalid proc file: %s is a symlink", filepath.Join(parts[:i+1]...))
+		}
+	}
+	return p.readFile(clean)
+}
+
+// openFile opens a file under this process's proc directory, reporting a
+// missing file as readFile does.
+func (p *Process) openFile(name string) (*os.File, error) {
+	file, err := os.Open(p.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return nil, p.wrapErr("read "+name, err)
+	}
+	return file, nil
+}
+
+// StatusMap returns every field of /proc/<pid>/status, keyed by field name
+// without the trailing colon.  Values are trimmed but otherwise unparsed, so
+// multi-value fields like Uid are still tab-separated.
+func (p *Process) StatusMap() (map[string]string, error) {
+	data, err := p.readFile("status")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(data), nil
+}
+
+// parseStatus parses the "Key:\tvalue" lines of a status file.
+func parseStatus(data []byte) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields[parts[0]] = strings.TrimSpace(parts[1])
+	}
+	return fields
+}
+
+// scanStatus reads the "Key:\tvalue" lines of a status file like
+// parseStatus, but only keeps those in keys, and stops reading as soon as it
+// has all of them.
+func scanStatus(r io.Reader, keys ...string) (map[string]string, error) {
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+	err := scanStatusValues(r, keys, values, found)
+	fields := make(map[string]string, len(keys))
+	for i, key := range keys {
+		if found[i] {
+			fields[key] = values[i]
+		}
+	}
+	return fields, err
+}
+
+// scanStatusValues is scanStatus, which stores the value of keys[i] in
+// values[i], and sets found[i] if there is one, rather than allocating a map.
+func scanStatusValues(r io.Reader, keys, values []string, found []bool) error {
+	buf := statusBufs.Get().(*[statusBufSize]byte)
+	defer statusBufs.Put(buf)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(buf[:], bufio.MaxScanTokenSize)
+	for n := 0; n < len(keys) && scanner.Scan(); {
+		line := scanner.Bytes()
+		i := bytes.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		// There are only ever a few keys, so a map wouldn't be faster.
+		for k, key := range keys {
+			if !found[k] && string(line[:i]) == key {
+				values[k] = string(bytes.TrimSpace(line[i+1:]))
+				found[k] = true
+				n++
+				break
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// statusBufSize is the size of the buffers scanStatus reads with, which is
+// enough for the longest lines of a status file.
+const statusBufSize = 4096
+
+// statusBufs holds buffers for scanStatus, which would otherwise allocate one
+// for every status file it reads, and so for every process a scan looks at.
+var statusBufs = sync.Pool{New: func() interface{} { return new([statusBufSize]byte) }}
+
+// statusField returns the value of a single field of /proc/<pid>/status,
+// reading no more of the file than it needs to.  A missing field is an
+// error.  It is called for every process when looking for them by name, so it
+// avoids allocating where it can.
+func (p *Process) statusField(key string) (string, error) {
+	file, err := p.openFile("status")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var values [1]string
+	var found [1]bool
+	if err := scanStatusValues(file, []string{key}, values[:], found[:]); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			// The process exited while we were reading.
+			err = ErrProcessNotFound
+		}
+		return "", p.wrapErr("read status", err)
+	}
+	if !found[0] {
+		return "", p.errorf("read status", "no %s field", key)
+	}
+	return values[0], nil
+}
+
+// statFields returns the fields of /proc/<pid>/stat which follow the comm
+// field, so that index 0 is the state (field 3 in proc(5)).
+func (p *Process) statFields() ([]string, error) {
+	data, err := p.readFile("stat")
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := parseStat(data)
+	if !ok {
+		return nil, p.errorf("read stat", "malformed stat")
+	}
+	return fields, nil
+}
+
+// parseStat splits a stat line into the fields which follow the comm field.
+// The comm field is wrapped in parens and may itself contain spaces and
+// parens, so the split is after the last one.  Kernels add fields to the end
+// over time, so callers must not assume how many there are.
+func parseStat(data []byte) ([]string, bool) {
+	stat := string(data)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return nil, false
+	}
+	return strings.Fields(stat[i+1:]), true
+}
+
+// statField returns field n of /proc/<pid>/stat, numbered from 1 as in
+// proc(5), which calls it name.  Asking for a field which this kernel
+// doesn't have, or which follows the end of a truncated line, is an error.
+// n must be at least 3, since the pid and comm fields are handled
+// separately.
+func (p *Process) statField(n int, name string) (string, error) {
+	fields, err := p.statFields()
+	if err != nil {
+		return "", err
+	}
+	// statFields starts at field 3.
+	i := n - 3
+	if i < 0 || i >= len(fields) {
+		return "", p.errorf("read stat", "no %s (field %d) in stat, which has %d fields", name, n, len(fields)+2)
+	}
+	return fields[i], nil
+}
+
+// statInt returns field n of /proc/<pid>/stat, as statField does, parsed as
+// a signed integer of the given bit size.
+func (p *Process) statInt(n int, name string, bitSize int) (int64, error) {
+	val, err := p.statField(n, name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(val, 10, bitSize)
+	if err != nil {
+		return 0, p.errorf("read stat", "malformed %s %q: %v", name, val, err)
+	}
+	return i, nil
+}
+
+// Name returns the name of the process.  The kernel truncates names to 15
+// characters, so longer names are recovered from the command line when
+// possible.
+func (p *Process) Name() (string, error) {
+	name, err := p.statusField("Name")
+	if err != nil {
+		return "", err
+	}
+	if len(name) >= maxCommLen {
+		cmdline, err := p.CmdlineSlice()
+		if err == nil && len(cmdline) > 0 {
+			if extended := filepath.Base(cmdline[0]); strings.HasPrefix(extended, name) {
+				name = extended
+			}
+		}
+	}
+	return name, nil
+}
+
+// NameStrategy says where ResolveName gets a process's name from.
+type NameStrategy int
+
+const (
+	// NameAuto is the Name heuristic: the status Name, extended from the
+	// command line if it looks truncated.
+	NameAuto NameStrategy = iota
+	// NameComm is the contents of /proc/<pid>/comm, truncated by the kernel
+	// to 15 characters.
+	NameComm
+	// NameStatus is the Name field of /proc/<pid>/status.  This is the same
+	// as NameComm, but escapes unprintable characters.
+	NameStatus
+	// NameArgv0Base is the base name of the first command line argument,
+	// which a process may change, and which kernel threads don't have.
+	NameArgv0Base
+)
+
+func (s NameStrategy) String() string {
+	switch s {
+	case NameAuto:
+		return "auto"
+	case NameComm:
+		return "comm"
+	case NameStatus:
+		return "status"
+	case NameArgv0Base:
+		return "argv0"
+	}
+	return fmt.Sprintf("NameStrategy(%d)", int(s))
+}
+
+// ResolveName returns the name of the process, from the source strategy
+// says.
+func (p *Process) ResolveName(strategy NameStrategy) (string, error) {
+	switch strategy {
+	case NameAuto:
+		return p.Name()
+	case NameComm:
+		data, err := p.readFile("comm")
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(string(data), "\n"), nil
+	case NameStatus:
+		return p.statusField("Name")
+	case NameArgv0Base:
+		args, err := p.CmdlineSlice()
+		if err != nil {
+			return "", err
+		}
+		if len(args) == 0 || args[0] == "" {
+			return "", p.errorf("read cmdline", "empty command line")
+		}
+		return filepath.Base(args[0]), nil
+	}
+	return "", fmt.Errorf("invalid name strategy %v", strategy)
+}
+
+// Ppid returns the PID of the parent process.
+func (p *Process) Ppid() (int32, error) {
+	val, err := p.statusField("PPid")
+	if err != nil {
+		return 0, err
+	}
+	ppid, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return 0, p.errorf("read status", "malformed PPid %q: %v", val, err)
+	}
+	return int32(ppid), nil
+}
+
+// TracerPid returns the PID of the process tracing this one, e.g. a
+// debugger, which sees its signals before it does.  It is 0 if the process
+// isn't being traced.
+func (p *Process) TracerPid() (int32, error) {
+	val, err := p.statusField("TracerPid")
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return 0, p.errorf("read status", "malformed TracerPid %q: %v", val, err)
+	}
+	return int32(pid), nil
+}
+
+// Nice returns the nice value of the process, from -20 (most favorable to
+// the process) to 19.
+func (p *Process) Nice() (int32, error) {
+	nice, err := p.statInt(19, "nice", 32)
+	return int32(nice), err
+}
+
+// Terminal returns the device number of the process's controlling terminal,
+// with the minor number in bits 0-7 and 20-31 and the major number in bits
+// 8-15, or 0 if it has none.
+func (p *Process) Terminal() (int32, error) {
+	tty, err := p.statInt(7, "tty_nr", 32)
+	return int32(tty), err
+}
+
+// PageFaultsStat holds the page fault counts of a process, from fields 10 to
+// 13 of /proc/<pid>/stat.  Major faults needed a read from disk, and a high
+// rate of them is the sign of a process thrashing.
+type PageFaultsStat struct {
+	Minor, Major uint64
+	// ChildMinor and ChildMajor are those of its children which have
+	// exited and been waited for.
+	ChildMinor, ChildMajor uint64
+}
+
+// PageFaults returns the page fault counts of the process.
+func (p *Process) PageFaults() (*PageFaultsStat, error) {
+	fields, err := p.statFields()
+	if err != nil {
+		return nil, err
+	}
+	// statFields starts at field 3.
+	if len(fields) <= 13-3 {
+		return nil, p.errorf("read stat", "no page faults in stat, which has %d fields", len(fields)+2)
+	}
+	var counts [4]uint64
+	for i, name := range []string{"minflt", "cminflt", "majflt", "cmajflt"} {
+		val := fields[10-3+i]
+		if counts[i], err = strconv.ParseUint(val, 10, 64); err != nil {
+			return nil, p.errorf("read stat", "malformed %s %q: %v", name, val, err)
+		}
+	}
+	return &PageFaultsStat{Minor: counts[0], ChildMinor: counts[1], Major: counts[2], ChildMajor: counts[3]}, nil
+}
+
+// Status returns the single-letter state of the process, e.g. "R" for
+// running or "Z" for zombie.
+func (p *Process) Status() (string, error) {
+	val, err := p.statusField("State")
+	if err != nil {
+		return "", err
+	}
+	if val == "" {
+		return "", p.errorf("read status", "empty State")
+	}
+	return val[:1], nil
+}
+
+// IsZombie returns true if the process has exited but not yet been reaped by
+// its parent.
+func (p *Process) IsZombie() (bool, error) {
+	state, err := p.Status()
+	if err != nil {
+		return false, err
+	}
+	return state == "Z", nil
+}
+
+// CreateTime returns the start time of the process, in milliseconds since
+// the epoch.  The start time is read once and then remembered, so that it
+// keeps identifying the original process even if its PID is reused.
+func (p *Process) CreateTime() (int64, error) {
+	if ct := atomic.LoadInt64(&p.createTime); ct != 0 {
+		return ct, nil
+	}
+	ct, err := p.readCreateTime()
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&p.createTime, ct)
+	return ct, nil
+}
+
+// StartTime returns the start time of the process, as CreateTime does, but
+// as a time.Time.  The kernel records it in clock ticks since boot, so it is
+// only accurate to a hundredth of a second.
+func (p *Process) StartTime() (time.Time, error) {
+	ct, err := p.CreateTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ct*int64(time.Millisecond)), nil
+}
+
+// StartTimeString returns the start time of the process in UTC, formatted
+// like "2017-07-14T02:40:02.5Z", for logging.
+func (p *Process) StartTimeString() (string, error) {
+	start, err := p.StartTime()
+	if err != nil {
+		return "", err
+	}
+	return start.UTC().Format(time.RFC3339Nano), nil
+}
+
+// Age returns how long ago the process started.  If the process has exited,
+// the error matches ErrProcessNotFound; any other failure to read the start
+// time, such as a malformed stat, is a *ProcessError which doesn't.
+func (p *Process) Age() (time.Duration, error) {
+	return p.ageAt(clockOr(p.fs.clock).Now())
+}
+
+// ageAt is Age, as of now.
+func (p *Process) ageAt(now time.Time) (time.Duration, error) {
+	start, err := p.StartTime()
+	var perr *ProcessError
+	switch {
+	case err == nil:
+		return now.Sub(start), nil
+	case errors.Is(err, ErrProcessNotFound):
+		return 0, err
+	case errors.Is(err, syscall.ESRCH):
+		// The process exited while its stat was being read.
+		return 0, p.wrapErr("read stat", ErrProcessNotFound)
+	case errors.As(err, &perr):
+		return 0, err
+	default:
+		return 0, p.wrapErr("read start time", err)
+	}
+}
+
+// Equal returns true if p and other are the same process: that is, they have
+// the same PID and the same start time.  Comparing PIDs alone is not enough,
+// since the kernel reuses them.
+func (p *Process) Equal(other *Process) (bool, error) {
+	if p.Pid != other.Pid {
+		return false, nil
+	}
+	pct, err := p.CreateTime()
+	if err != nil {
+		return false, err
+	}
+	oct, err := other.CreateTime()
+	if err != nil {
+		return false, err
+	}
+	return pct == oct, nil
+}
+
+// readCreateTime reads the current start time of whatever process has this
+// PID, bypassing the cache.
+func (p *Process) readCreateTime() (int64, error) {
+	val, err := p.statField(22, "starttime")
+	if err != nil {
+		return 0, err
+	}
+	ticks, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, p.errorf("read stat", "malformed starttime %q: %v", val, err)
+	}
+	boot, err := p.fs.bootTime()
+	if err != nil {
+		return 0, err
+	}
+	return int64((float64(boot) + float64(ticks)/clockTicks) * 1000), nil
+}
+
+// bootTime returns the system boot time, in seconds since the epoch.  It is
+// normally the btime line of /proc/stat, which the kernel fixes at boot.  Some
+// proc filesystems, such as those emulated by sandboxes, have no btime, in
+// which case the boot time is worked out from /proc/uptime instead.  That is
+// only as accurate as the clock is steady, since it subtracts the uptime
+// from the current time.  Either way, it is worked out once per proc
+// filesystem and remembered, which saves reading /proc/stat for every
+// process, and means that start times can still be compared.
+func (fs ProcFS) bootTime() (uint64, error) {
+	path := fs.path("stat")
+	if boot, ok := bootTimes.Load(path); ok {
+		return boot.(uint64), nil
+	}
+	boot, err := fs.readBootTime(path)
+	if err != nil {
+		return 0, err
+	}
+	actual, _ := bootTimes.LoadOrStore(path, boot)
+	return actual.(uint64), nil
+}
+
+// bootTimes holds the boot time found by bootTime for each proc filesystem,
+// by the path of its /proc/stat.
+var bootTimes sync.Map
+
+// readBootTime returns the btime line of statPath, or the boot time from
+// uptimeBootTime if it has none.
+func (fs ProcFS) readBootTime(statPath string) (uint64, error) {
+	data, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "btime ") {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(line[len("btime "):]), 10, 64)
+	}
+	return fs.uptimeBootTime()
+}
+
+// uptimeBootTime returns the boot time, in seconds since the epoch, as the
+// current time less the uptime from /proc/uptime.
+func (fs ProcFS) uptimeBootTime() (uint64, error) {
+	path := fs.path("uptime")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("no btime in %s, and no %s", fs.path("stat"), path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("malformed %s: %q", path, data)
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || uptime < 0 {
+		return 0, fmt.Errorf("malformed uptime %q in %s", fields[0], path)
+	}
+	now := float64(clockOr(fs.clock).Now().UnixNano()) / 1e9
+	return uint64(math.Round(now - uptime)), nil
+}
+
+// Cmdline returns the command line of the process, joined with spaces.
+func (p *Process) Cmdline() (string, error) {
+	args, err := p.CmdlineSlice()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(args, " "), nil
+}
+
+// MaxCmdlineBytes is how much of a command line CmdlineSlice and Cmdline
+// read, unless ProcFS.WithMaxCmdlineBytes says otherwise.  Anything beyond
+// this is dropped, to bound the memory used on processes with enormous
+// argument lists.
+const MaxCmdlineBytes = 64 * 1024
+
+// CmdlineSlice returns the command line arguments of the process.  Kernel
+// threads and zombies have no command line, and return an empty slice.
+// Command lines longer than MaxCmdlineBytes, or the limit given to the
+// ProcFS by WithMaxCmdlineBytes, are silently truncated; use
+// CmdlineSliceWithLimit to find out when that happens.
+func (p *Process) CmdlineSlice() ([]string, error) {
+	args, _, err := p.fillSliceFromCmdlineWithContext(context.Background(), p.fs.cmdlineLimit())
+	return args, err
+}
+
+// CmdlineSliceWithLimit is CmdlineSlice, but reads at most limit bytes and
+// reports whether the command line was longer than that.  If so, the last
+// argument returned may be cut short.
+func (p *Process) CmdlineSliceWithLimit(limit int) ([]string, bool, error) {
+	return p.fillSliceFromCmdlineWithContext(context.Background(), limit)
+}
+
+func (p *Process) fillSliceFromCmdlineWithContext(ctx context.Context, limit int) ([]string, bool, error) {
+	f, err := p.openFile("cmdline")
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	if limit < 0 {
+		limit = 0
+	}
+	// Read one byte more than the limit, to tell whether there was more.
+	data, err := ioutil.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return nil, false, p.wrapErr("read cmdline", err)
+	}
+	args, truncated := parseCmdline(data, limit)
+	return args, truncated, nil
+}
+
+// parseCmdline splits the NUL-separated arguments of a cmdline file, of
+// which only the first limit bytes are used.  It reports whether data was
+// longer than that.
+func parseCmdline(data []byte, limit int) ([]string, bool) {
+	if limit < 0 {
+		limit = 0
+	}
+	truncated := len(data) > limit
+	if truncated {
+		data = data[:limit]
+	}
+	if len(data) == 0 {
+		return []string{}, truncated
+	}
+	if data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return strings.Split(string(data), "\x00"), truncated
+}
+
+// Wchan returns the name of the kernel function the process is blocked in,
+// or "" if it is not blocked.
+func (p *Process) Wchan() (string, error) {
+	data, err := p.readFile("wchan")
+	if err != nil {
+		return "", err
+	}
+	wchan := strings.TrimSpace(string(data))
+	if wchan == "0" {
+		// The kernel reports a runnable process as "0".
+		return "", nil
+	}
+	return wchan, nil
+}
+
+// Cwd returns the current working directory of the process, as the kernel
+// reports it, which is with symlinks resolved and as the host sees it; use
+// HostPath to find it from here.  Only the process's owner, or root, may read
+// it.
+func (p *Process) Cwd() (string, error) {
+	cwd, err := os.Readlink(p.path("cwd"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return "", p.wrapErr("read cwd", err)
+	}
+	return cwd, nil
+}
+
+// Exe returns the path of the executable the process is running, with
+// symlinks resolved.  If the file has since been deleted or replaced, as
+// after a package upgrade, the kernel appends " (deleted)".  Like Cwd, it is
+// as the host sees it; use HostPath to find it from here.  Only the
+// process's owner, or root, may read it.
+func (p *Process) Exe() (string, error) {
+	exe, err := os.Readlink(p.path("exe"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return "", p.wrapErr("read exe", err)
+	}
+	return exe, nil
+}
+
+// Root returns the root directory of the process, which is other than / if
+// it has been chroot'ed, or is in a container with its own mount namespace.
+// Like Cwd, it is as the host sees it; use HostPath to find it from here.
+// Only the process's owner, or root, may read it.
+func (p *Process) Root() (string, error) {
+	root, err := os.Readlink(p.path("root"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrProcessNotFound
+		}
+		return "", p.wrapErr("read root", err)
+	}
+	return root, nil
+}
+
+// IsRunning returns true if the process still exists.
+func (p *Process) IsRunning() (bool, error) {
+	if _, err := os.Stat(p.path()); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, p.wrapErr("stat", err)
+	}
+	return true, nil
+}
+
+// processesByName returns all processes named name.  Processes which exit or
+// can't be read while scanning are skipped.
+func processesByName(ctx context.Context, name string) ([]*Process, error) {
+	return ProcFS{}.processesByName(ctx, name)
+}
+
+// processesByName returns all processes in this proc filesystem named name.
+func (fs ProcFS) processesByName(ctx context.Context, name string) ([]*Process, error) {
+	return fs.processesMatching(ctx, func(p *Process) (bool, error) {
+		n, err := p.Name()
+		return n == name, err
+	})
+}
+
+// processesMatching returns all processes in this proc filesystem for which
+// match returns true.  Processes for which it returns an error are skipped.
+func (fs ProcFS) processesMatching(ctx context.Context, match func(*Process) (bool, error)) ([]*Process, error) {
+	return fs.processesMatchingWithPolicy(ctx, match, SkipMatchErrors)
+}
+
+// processesMatchingWithPolicy is processesMatching, which handles errors from
+// match according to policy.
+func (fs ProcFS) processesMatchingWithPolicy(ctx context.Context, match func(*Process) (bool, error), policy MatchErrorPolicy) ([]*Process, error) {
+	var matches []*Process
+	var matchErr error
+	err := fs.ForEachProcess(ctx, func(p *Process) bool {
+		ok, err := match(p)
+		if err != nil {
+			if policy == AbortOnMatchError && !errors.Is(err, ErrProcessNotFound) {
+				// Say which process, unless the error already does.
+				var perr *ProcessError
+				if !errors.As(err, &perr) {
+					err = p.wrapErr("match", err)
+				}
+				matchErr = err
+				return false
+			}
+			countSkip(err)
+		} else if ok {
+			matches = append(matches, p)
+		}
+		return true
+	})
+	if err == nil {
+		err = matchErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
@@ -0,0 +1,1127 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureBootTime is the btime written to the fixture's /proc/stat.
+const fixtureBootTime = 1500000000
+
+// fixture is a fake proc filesystem, which HOST_PROC points at until
+// cleanup is called.
+type fixture struct {
+	t    testing.TB
+	root string
+	undo []func()
+}
+
+func newFixture(t testing.TB) *fixture {
+	t.Helper()
+	f := newRootFixture(t)
+	f.setenv("HOST_PROC", f.root)
+	return f
+}
+
+// newRootFixture returns a fixture which HOST_PROC does not point at, for use
+// through NewProcFS.
+func newRootFixture(t testing.TB) *fixture {
+	t.Helper()
+	f := newEmptyFixture(t)
+	f.write("stat", fmt.Sprintf("cpu  1 2 3 4\nbtime %d\nprocesses 7\n", fixtureBootTime))
+	return f
+}
+
+// newEmptyFixture returns a fixture rooted at a new, empty directory.
+func newEmptyFixture(t testing.TB) *fixture {
+	t.Helper()
+	root, remove := tempDir(t)
+	return &fixture{t: t, root: root, undo: []func(){remove}}
+}
+
+// setenv sets an environment variable until cleanup is called.
+func (f *fixture) setenv(key, value string) {
+	f.undo = append(f.undo, setenv(key, value))
+}
+
+// cleanup removes the fixture and undoes any setenv calls, latest first.
+func (f *fixture) cleanup() {
+	for i := len(f.undo) - 1; i >= 0; i-- {
+		f.undo[i]()
+	}
+	f.undo = nil
+}
+
+// tempDir creates a temporary directory, returning it and a function which
+// removes it.
+func tempDir(t testing.TB) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "process-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// setenv sets an environment variable, returning a function which restores
+// its previous value.
+func setenv(key, value string) func() {
+	orig, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, orig)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// write creates a file under the fixture root, creating parent directories
+// as needed.
+func (f *fixture) write(rel, content string) {
+	f.t.Helper()
+	path := filepath.Join(f.root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatalf("failed to create dir for %s: %v", rel, err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		f.t.Fatalf("failed to write %s: %v", rel, err)
+	}
+}
+
+// fakeProc describes a process in the fixture.
+type fakeProc struct {
+	pid   int32
+	name  string
+	state string
+	ppid  int32
+	// start is the starttime field of stat, in clock ticks since boot.
+	start   uint64
+	cmdline []string
+}
+
+// add writes the status, stat and cmdline files for fp.
+func (f *fixture) add(fp fakeProc) {
+	f.t.Helper()
+	if fp.state == "" {
+		fp.state = "S"
+	}
+	dir := strconv.Itoa(int(fp.pid))
+	f.write(filepath.Join(dir, "status"), fmt.Sprintf(
+		"Name:\t%s\nUmask:\t0022\nState:\t%s (sleeping)\nTgid:\t%d\nPid:\t%d\nPPid:\t%d\nTracerPid:\t0\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\n",
+		fp.name, fp.state, fp.pid, fp.pid, fp.ppid))
+	f.write(filepath.Join(dir, "stat"), fmt.Sprintf(
+		"%d (%s) %s %d %d %d 0 -1 4194304 81 0 0 0 0 0 0 0 20 0 1 0 %d 2703360 287 18446744073709551615\n",
+		fp.pid, fp.name, fp.state, fp.ppid, fp.pid, fp.pid, fp.start))
+	cmdline := ""
+	for _, arg := range fp.cmdline {
+		cmdline += arg + "\x00"
+	}
+	f.write(filepath.Join(dir, "cmdline"), cmdline)
+}
+
+func TestPids(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "init"})
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("self/status", "Name:\tself\n")
+	f.write("meminfo", "MemTotal: 1 kB\n")
+
+	pids, err := Pids(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{1, 42}; !reflect.DeepEqual(pids, exp) {
+		t.Errorf("expected %v, got %v", exp, pids)
+	}
+}
+
+func TestForEachProcess(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 5; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker"})
+	}
+
+	var visited []int32
+	err := ForEachProcess(context.Background(), func(p *Process) bool {
+		visited = append(visited, p.Pid)
+		return p.Pid < 3
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{1, 2, 3}; !reflect.DeepEqual(visited, exp) {
+		t.Errorf("expected to stop after %v, visited %v", exp, visited)
+	}
+}
+
+func TestPidsSorted(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	var exp []int32
+	for i := int32(1); i <= 200; i++ {
+		exp = append(exp, i*7)
+	}
+	// Create the entries out of order, so the directory order is unlikely
+	// to match.
+	for _, i := range rand.New(rand.NewSource(1)).Perm(len(exp)) {
+		f.add(fakeProc{pid: exp[i], name: "worker"})
+	}
+
+	for run := 0; run < 5; run++ {
+		pids, err := Pids(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(pids, exp) {
+			t.Fatalf("run %d: expected sorted pids, got %v", run, pids)
+		}
+	}
+}
+
+// addPidDirs adds n empty process directories to f, which is all Pids looks
+// at, numbered from 1.
+func addPidDirs(t testing.TB, f *fixture, n int) []int32 {
+	var pids []int32
+	for pid := int32(1); pid <= int32(n); pid++ {
+		if err := os.Mkdir(filepath.Join(f.root, strconv.Itoa(int(pid))), 0755); err != nil {
+			t.Fatalf("failed to create pid dir: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+func TestPidsBatched(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	exp := addPidDirs(t, f, 500)
+	f.write("meminfo", "MemTotal: 1 kB\n")
+
+	for _, batch := range []int{0, 1, 7, 100, 500, 10000} {
+		pids, err := NewProcFS(f.root).WithReadDirBatchSize(batch).Pids(context.Background())
+		if err != nil {
+			t.Fatalf("batch %d: unexpected error: %v", batch, err)
+		}
+		if !reflect.DeepEqual(pids, exp) {
+			t.Errorf("batch %d: expected %d pids, got %d", batch, len(exp), len(pids))
+		}
+	}
+}
+
+// countdownContext is a context which reports being cancelled once Err has
+// been called a given number of times.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining <= 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+func TestPidsBatchedCancel(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	addPidDirs(t, f, 2000)
+	fs := NewProcFS(f.root).WithReadDirBatchSize(100)
+
+	// Cancelled after the first few batches.
+	ctx := &countdownContext{Context: context.Background(), remaining: 3}
+	if pids, err := fs.Pids(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %d pids: %v", len(pids), err)
+	}
+	if ctx.remaining != 0 {
+		t.Errorf("expected the context to be checked between batches")
+	}
+
+	// Reading everything at once only checks before starting.
+	ctx = &countdownContext{Context: context.Background(), remaining: 1}
+	if pids, err := NewProcFS(f.root).Pids(ctx); err != nil || len(pids) != 2000 {
+		t.Errorf("expected 2000 pids, got %d: %v", len(pids), err)
+	}
+}
+
+func TestPidsNotProcFS(t *testing.T) {
+	// A directory with numeric names, but nothing else proc-like.
+	f := newEmptyFixture(t)
+	defer f.cleanup()
+	root := f.root
+	f.setenv("HOST_PROC", root)
+	for _, name := range []string{"1", "2", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	if _, err := Pids(context.Background()); err == nil || !strings.Contains(err.Error(), "HOST_PROC") {
+		t.Errorf("expected a configuration error, got %v", err)
+	}
+
+	pids, err := ProcFS{}.WithoutProcFSCheck().Pids(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pids) != 2 {
+		t.Errorf("expected 2 pids with the check disabled, got %v", pids)
+	}
+}
+
+func TestOpenWithRetry(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	// flakyOpen fails the first n calls.
+	flakyOpen := func(n int) (func(string) (*os.File, error), *int) {
+		calls := 0
+		return func(path string) (*os.File, error) {
+			calls++
+			if calls <= n {
+				return nil, fmt.Errorf("transient failure %d", calls)
+			}
+			return os.Open(path)
+		}, &calls
+	}
+
+	open, calls := flakyOpen(2)
+	d, err := openWithRetry(context.Background(), open, dir, 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.Close()
+	if *calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", *calls)
+	}
+
+	open, calls = flakyOpen(5)
+	if _, err := openWithRetry(context.Background(), open, dir, 3, time.Millisecond); err == nil || err.Error() != "transient failure 3" {
+		t.Errorf("expected the last error, got %v", err)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", *calls)
+	}
+
+	// A cancelled context stops the wait rather than sleeping it out.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	open, calls = flakyOpen(5)
+	if _, err := openWithRetry(ctx, open, dir, 3, time.Hour); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("expected 1 attempt, got %d", *calls)
+	}
+}
+
+func TestNewProcess(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+
+	if _, err := NewProcess(42); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewProcess(43); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestTracerPid(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("43/status", "Name:\tnginx\nTracerPid:\t1234\n")
+	f.write("44/status", "Name:\tnginx\nTracerPid:\tbogus\n")
+
+	for pid, exp := range map[int32]int32{42: 0, 43: 1234} {
+		tracer, err := (&Process{Pid: pid}).TracerPid()
+		if err != nil {
+			t.Errorf("pid %d: unexpected error: %v", pid, err)
+			continue
+		}
+		if tracer != exp {
+			t.Errorf("pid %d: expected tracer %d, got %d", pid, exp, tracer)
+		}
+	}
+	if _, err := (&Process{Pid: 44}).TracerPid(); err == nil {
+		t.Errorf("expected an error for a malformed TracerPid")
+	}
+}
+
+func TestSelf(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("can't find the test binary: %v", err)
+	}
+	name, err := Self().Name()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := filepath.Base(exe); name != exp {
+		t.Errorf("expected %q, got %q", exp, name)
+	}
+	if ppid, err := Self().Ppid(); err != nil || ppid != int32(os.Getppid()) {
+		t.Errorf("expected ppid %d, got %d: %v", os.Getppid(), ppid, err)
+	}
+}
+
+func TestName(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "nginx", cmdline: []string{"/usr/sbin/nginx", "-g", "daemon off;"}})
+	// The kernel truncates names to 15 characters.
+	f.add(fakeProc{pid: 2, name: "a-very-long-nam", cmdline: []string{"/bin/a-very-long-name", "--flag"}})
+	// A truncated name with a cmdline that doesn't match is left alone.
+	f.add(fakeProc{pid: 3, name: "a-very-long-nam", cmdline: []string{"python", "script.py"}})
+	// A kernel thread has no cmdline.
+	f.add(fakeProc{pid: 4, name: "kworker/0:1-eve"})
+
+	cases := []struct {
+		pid  int32
+		name string
+	}{
+		{1, "nginx"},
+		{2, "a-very-long-name"},
+		{3, "a-very-long-nam"},
+		{4, "kworker/0:1-eve"},
+	}
+	for _, tc := range cases {
+		name, err := (&Process{Pid: tc.pid}).Name()
+		if err != nil {
+			t.Errorf("pid %d: unexpected error: %v", tc.pid, err)
+			continue
+		}
+		if name != tc.name {
+			t.Errorf("pid %d: expected name %q, got %q", tc.pid, tc.name, name)
+		}
+	}
+
+	if _, err := (&Process{Pid: 5}).Name(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestResolveName(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "status-name", cmdline: []string{"/usr/bin/argv-name", "--flag"}})
+	f.write("42/comm", "comm-name\n")
+	// A kernel thread has no command line.
+	f.add(fakeProc{pid: 43, name: "kthreadd"})
+	f.write("43/comm", "kthreadd\n")
+	p := &Process{Pid: 42}
+
+	for strategy, exp := range map[NameStrategy]string{
+		NameAuto:      "status-name",
+		NameComm:      "comm-name",
+		NameStatus:    "status-name",
+		NameArgv0Base: "argv-name",
+	} {
+		name, err := p.ResolveName(strategy)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", strategy, err)
+			continue
+		}
+		if name != exp {
+			t.Errorf("%v: expected %q, got %q", strategy, exp, name)
+		}
+	}
+
+	if name, err := (&Process{Pid: 43}).ResolveName(NameArgv0Base); err == nil {
+		t.Errorf("expected an error for a kernel thread, got %q", name)
+	}
+	if _, err := (&Process{Pid: 44}).ResolveName(NameComm); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+	if _, err := p.ResolveName(NameStrategy(99)); err == nil {
+		t.Errorf("expected an error for an invalid strategy")
+	}
+}
+
+func TestStatusFields(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", state: "Z", ppid: 7})
+	p := &Process{Pid: 42}
+
+	ppid, err := p.Ppid()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ppid != 7 {
+		t.Errorf("expected ppid 7, got %d", ppid)
+	}
+
+	state, err := p.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state != "Z" {
+		t.Errorf("expected state Z, got %q", state)
+	}
+}
+
+func TestProcessError(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("42/limits", "Max open files            lots                 4096                 files\n")
+
+	cases := []struct {
+		name     string
+		call     func(p *Process) error
+		pid      int32
+		op       string
+		notFound bool
+	}{
+		{"missing status", func(p *Process) error { _, err := p.Name(); return err }, 43, "read status", true},
+		{"missing stat", func(p *Process) error { _, err := p.Nice(); return err }, 43, "read stat", true},
+		{"missing cmdline", func(p *Process) error { _, err := p.CmdlineSlice(); return err }, 43, "read cmdline", true},
+		{"missing field", func(p *Process) error { _, err := p.statusField("Bogus"); return err }, 42, "read status", false},
+		{"malformed limit", func(p *Process) error { _, _, err := p.Limit("Max open files"); return err }, 42, "read limits", false},
+		{"missing process", func(p *Process) error { _, err := p.fs.NewProcess(p.Pid); return err }, 43, "stat", true},
+		{"missing fd", func(p *Process) error { _, err := p.FdTarget(9); return err }, 42, "read fd 9", false},
+		{"missing fdinfo", func(p *Process) error { _, err := p.FdInfo(9); return err }, 42, "read fdinfo/9", false},
+		{"missing smaps_rollup", func(p *Process) error { _, err := p.MemoryMapsRollup(); return err }, 42, "read smaps_rollup", false},
+	}
+	for _, tc := range cases {
+		err := tc.call(&Process{Pid: tc.pid})
+		var perr *ProcessError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: expected a *ProcessError, got %T: %v", tc.name, err, err)
+			continue
+		}
+		if perr.Pid != tc.pid || perr.Op != tc.op {
+			t.Errorf("%s: expected pid %d and op %q, got %d and %q", tc.name, tc.pid, tc.op, perr.Pid, perr.Op)
+		}
+		if errors.Is(err, ErrProcessNotFound) != tc.notFound {
+			t.Errorf("%s: expected errors.Is(err, ErrProcessNotFound) to be %v: %v", tc.name, tc.notFound, err)
+		}
+		if msg := err.Error(); !strings.Contains(msg, tc.op) || !strings.Contains(msg, fmt.Sprintf("pid %d", tc.pid)) {
+			t.Errorf("%s: expected the op and pid in %q", tc.name, msg)
+		}
+	}
+
+	err := (&ProcessError{Pid: 1234, Op: "read status", Err: ErrProcessNotFound}).Error()
+	if exp := "failed to read status for pid 1234: process not found"; err != exp {
+		t.Errorf("expected %q, got %q", exp, err)
+	}
+}
+
+func TestStatusMap(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("42/status", "Name:\tnginx\nState:\tS (sleeping)\nPPid:\t1\n"+
+		"Uid:\t1000\t1001\t1002\t1003\nGroups:\t \nVmRSS:\t    1234 kB\nnot a field\n")
+
+	fields, err := (&Process{Pid: 42}).StatusMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := map[string]string{
+		"Name":   "nginx",
+		"State":  "S (sleeping)",
+		"PPid":   "1",
+		"Uid":    "1000\t1001\t1002\t1003",
+		"Groups": "",
+		"VmRSS":  "1234 kB",
+	}
+	if !reflect.DeepEqual(fields, exp) {
+		t.Errorf("expected %q, got %q", exp, fields)
+	}
+
+	if _, err := (&Process{Pid: 43}).StatusMap(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestCreateTime(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", start: 250})
+	// A comm with spaces and parens must not shift the fields.
+	f.add(fakeProc{pid: 43, name: "evil) 1 2 (name", start: 250})
+
+	for _, pid := range []int32{42, 43} {
+		ct, err := (&Process{Pid: pid}).CreateTime()
+		if err != nil {
+			t.Fatalf("pid %d: unexpected error: %v", pid, err)
+		}
+		if exp := int64(fixtureBootTime*1000 + 2500); ct != exp {
+			t.Errorf("pid %d: expected create time %d, got %d", pid, exp, ct)
+		}
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", start: 250})
+	f.write("43/stat", "43 (broken) S\n")
+	p := &Process{Pid: 42}
+
+	start, err := p.StartTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := time.Unix(fixtureBootTime, 0).Add(2500 * time.Millisecond)
+	if diff := start.Sub(exp); diff < -10*time.Millisecond || diff > 10*time.Millisecond {
+		t.Errorf("expected a start time of %v, got %v", exp, start)
+	}
+	if ct, err := p.CreateTime(); err != nil || ct != start.UnixNano()/int64(time.Millisecond) {
+		t.Errorf("expected the create time to agree with %v, got %d: %v", start, ct, err)
+	}
+	if s, err := p.StartTimeString(); err != nil || s != "2017-07-14T02:40:02.5Z" {
+		t.Errorf("expected %q, got %q: %v", "2017-07-14T02:40:02.5Z", s, err)
+	}
+
+	if _, err := (&Process{Pid: 43}).StartTime(); err == nil {
+		t.Errorf("expected an error for a malformed stat")
+	}
+	if _, err := (&Process{Pid: 44}).StartTimeString(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestPageFaults(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("42/stat", "42 (make) S 1 42 42 0 -1 4194304 1534 98765 12 345 0 0 0 0 20 0 1 0 250 2703360 287\n")
+	f.write("43/stat", "43 (short) S 1 43 43 0 -1 4194304 1534 98765\n")
+	f.write("44/stat", "44 (bad) S 1 44 44 0 -1 4194304 1534 98765 -1 345\n")
+
+	faults, err := (&Process{Pid: 42}).PageFaults()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := (PageFaultsStat{Minor: 1534, ChildMinor: 98765, Major: 12, ChildMajor: 345}); *faults != exp {
+		t.Errorf("expected %+v, got %+v", exp, *faults)
+	}
+	for _, pid := range []int32{43, 44} {
+		if _, err := (&Process{Pid: pid}).PageFaults(); err == nil {
+			t.Errorf("pid %d: expected an error", pid)
+		}
+	}
+	if _, err := (&Process{Pid: 45}).PageFaults(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestCreateTimeBootTime(t *testing.T) {
+	clock := useFakeClock()
+	defer clock.restore()
+	clock.Advance(time.Unix(fixtureBootTime+5000, 0).Sub(clock.Now()))
+
+	// btime is preferred, even if uptime disagrees with it.
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("uptime", "1000.25 900.00\n")
+	f.add(fakeProc{pid: 42, name: "nginx", start: 250})
+	if ct, err := (&Process{Pid: 42}).CreateTime(); err != nil || ct != fixtureBootTime*1000+2500 {
+		t.Errorf("with btime: expected create time %d, got %d: %v", fixtureBootTime*1000+2500, ct, err)
+	}
+	// btime is also only read once per proc filesystem.
+	f.write("stat", "cpu  1 2 3 4\nbtime 1\nprocesses 7\n")
+	if ct, err := (&Process{Pid: 42}).CreateTime(); err != nil || ct != fixtureBootTime*1000+2500 {
+		t.Errorf("btime changed: expected create time %d, got %d: %v", fixtureBootTime*1000+2500, ct, err)
+	}
+
+	// Without btime, the boot time is now less the uptime, to the second.
+	f = newFixture(t)
+	defer f.cleanup()
+	f.write("stat", "cpu  1 2 3 4\nprocesses 7\n")
+	f.write("uptime", "1000.25 900.00\n")
+	f.add(fakeProc{pid: 42, name: "nginx", start: 250})
+	exp := int64((fixtureBootTime+4000)*1000 + 2500)
+	if ct, err := (&Process{Pid: 42}).CreateTime(); err != nil || ct != exp {
+		t.Errorf("without btime: expected create time %d, got %d: %v", exp, ct, err)
+	}
+	// Which is only worked out once, so that start times stay comparable as
+	// the clock moves.
+	clock.Advance(1500 * time.Millisecond)
+	if ct, err := (&Process{Pid: 42}).CreateTime(); err != nil || ct != exp {
+		t.Errorf("later: expected create time %d, got %d: %v", exp, ct, err)
+	}
+
+	// With neither, there's no way to tell.
+	f = newFixture(t)
+	defer f.cleanup()
+	f.write("stat", "cpu  1 2 3 4\nprocesses 7\n")
+	f.add(fakeProc{pid: 42, name: "nginx", start: 250})
+	if _, err := (&Process{Pid: 42}).CreateTime(); err == nil {
+		t.Errorf("expected an error without btime or uptime")
+	}
+	f.write("uptime", "soon\n")
+	if _, err := (&Process{Pid: 42}).CreateTime(); err == nil {
+		t.Errorf("expected an error for malformed uptime")
+	}
+}
+
+func TestReadProcFile(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.add(fakeProc{pid: 43, name: "secret"})
+	f.write("42/io", "rchar: 100\nwchar: 200\n")
+	f.write("42/net/dev", "lo: 0\n")
+	if err := os.Symlink(f.root, filepath.Join(f.root, "42", "root")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	p := &Process{Pid: 42}
+
+	for rel, exp := range map[string]string{
+		"io":        "rchar: 100\nwchar: 200\n",
+		"net/dev":   "lo: 0\n",
+		"net/../io": "rchar: 100\nwchar: 200\n",
+	} {
+		data, err := p.ReadProcFile(rel)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", rel, err)
+			continue
+		}
+		if string(data) != exp {
+			t.Errorf("%q: expected %q, got %q", rel, exp, data)
+		}
+	}
+
+	for _, rel := range []string{"", ".", "..", "../43/status", "net/../../43/status", "/etc/passwd", "root/43/status"} {
+		if data, err := p.ReadProcFile(rel); err == nil {
+			t.Errorf("%q: expected an error, got %q", rel, data)
+		}
+	}
+	if _, err := p.ReadProcFile("smaps"); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestStatFields(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// A newer kernel, with fields past the ones we know about, and a comm
+	// with spaces and parens.
+	long := "42 (a) b (c) S 1 40 30 34816 -1 4194560 100 0 0 0 5 3 0 0 20 -5 1 0 250 1000 200 18446744073709551615"
+	for i := 0; i < 30; i++ {
+		long += " 0"
+	}
+	f.write("42/stat", long+"\n")
+	// A line cut short after the nice field.
+	f.write("43/stat", "43 (short) S 1 43 43 0 -1 4194560 100 0 0 0 5 3 0 0 20 7\n")
+
+	p := &Process{Pid: 42}
+	if ct, err := p.CreateTime(); err != nil || ct != fixtureBootTime*1000+2500 {
+		t.Errorf("unexpected create time %d: %v", ct, err)
+	}
+	if nice, err := p.Nice(); err != nil || nice != -5 {
+		t.Errorf("expected nice -5, got %d: %v", nice, err)
+	}
+	if tty, err := p.Terminal(); err != nil || tty != 34816 {
+		t.Errorf("expected tty 34816, got %d: %v", tty, err)
+	}
+	if pgid, err := p.Pgid(); err != nil || pgid != 40 {
+		t.Errorf("expected pgid 40, got %d: %v", pgid, err)
+	}
+
+	short := &Process{Pid: 43}
+	if nice, err := short.Nice(); err != nil || nice != 7 {
+		t.Errorf("expected nice 7, got %d: %v", nice, err)
+	}
+	if ct, err := short.CreateTime(); err == nil {
+		t.Errorf("expected an error for a missing starttime, got %d", ct)
+	}
+	if _, err := short.statField(100, "future"); err == nil {
+		t.Errorf("expected an error for a field past the end")
+	}
+	if _, err := short.statField(2, "comm"); err == nil {
+		t.Errorf("expected an error for the comm field")
+	}
+}
+
+func TestAge(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", start: 250})
+	f.write("43/stat", "43 (broken) S\n")
+	clock := newFakeClock()
+	fs := ProcFS{}.WithClock(clock)
+	started := time.Unix(fixtureBootTime, 0).Add(2500 * time.Millisecond)
+	clock.Advance(started.Add(90 * time.Second).Sub(clock.Now()))
+
+	age, err := fs.process(42).Age()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if age != 90*time.Second {
+		t.Errorf("expected 90s, got %v", age)
+	}
+	// A stat which can't be parsed is an error, but not an exit.
+	var perr *ProcessError
+	if _, err := fs.process(43).Age(); !errors.As(err, &perr) || perr.Pid != 43 || errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("pid 43: expected a ProcessError other than ErrProcessNotFound, got %v", err)
+	}
+	if _, err := fs.process(44).Age(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("pid 44: expected ErrProcessNotFound, got %v", err)
+	}
+
+	// So it can be ignored when matching.
+	if ok, err := fs.process(43).Matches(MatchSpec{MinAge: time.Minute, IgnoreUnreadable: true}); err != nil || !ok {
+		t.Errorf("pid 43: expected an unreadable age to be ignored, got %v: %v", ok, err)
+	}
+}
+
+func TestCmdlineLimit(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("1/cmdline", "server\x00"+strings.Repeat("x", 100)+"\x00")
+	f.write("2/cmdline", "server\x00-v\x00")
+	p := &Process{Pid: 1}
+
+	args, truncated, err := p.CmdlineSliceWithLimit(20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !truncated {
+		t.Errorf("expected the cmdline to be truncated")
+	}
+	if exp := []string{"server", strings.Repeat("x", 13)}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected %q, got %q", exp, args)
+	}
+
+	// Exactly at the limit is not truncated.
+	args, truncated, err = p.CmdlineSliceWithLimit(108)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if truncated {
+		t.Errorf("expected the cmdline to not be truncated")
+	}
+	if exp := []string{"server", strings.Repeat("x", 100)}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected %q, got %q", exp, args)
+	}
+
+	fs := ProcFS{}.WithMaxCmdlineBytes(10)
+	args, err = fs.process(1).CmdlineSlice()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"server", "xxx"}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected %q, got %q", exp, args)
+	}
+	args, err = fs.process(2).CmdlineSlice()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"server", "-v"}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected %q, got %q", exp, args)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", start: 100})
+	f.add(fakeProc{pid: 43, name: "nginx", start: 100})
+
+	orig := &Process{Pid: 42}
+	same := &Process{Pid: 42}
+	if eq, err := orig.Equal(same); err != nil || !eq {
+		t.Errorf("expected identical processes to be equal, got %v: %v", eq, err)
+	}
+	if eq, err := orig.Equal(&Process{Pid: 43}); err != nil || eq {
+		t.Errorf("expected different PIDs to not be equal, got %v: %v", eq, err)
+	}
+
+	// The process exits and a new one reuses its PID.
+	f.add(fakeProc{pid: 42, name: "nginx", start: 200})
+	reused := &Process{Pid: 42}
+	if eq, err := orig.Equal(reused); err != nil || eq {
+		t.Errorf("expected a reused PID to not be equal, got %v: %v", eq, err)
+	}
+	if eq, err := same.Equal(orig); err != nil || !eq {
+		t.Errorf("expected handles to the original process to still be equal, got %v: %v", eq, err)
+	}
+
+	if _, err := (&Process{Pid: 44}).Equal(&Process{Pid: 44}); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestCmdline(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "nginx", cmdline: []string{"/usr/sbin/nginx", "-g", "daemon off;"}})
+	f.add(fakeProc{pid: 2, name: "kthreadd"})
+
+	args, err := (&Process{Pid: 1}).CmdlineSlice()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"/usr/sbin/nginx", "-g", "daemon off;"}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected %q, got %q", exp, args)
+	}
+	cmdline, err := (&Process{Pid: 1}).Cmdline()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := "/usr/sbin/nginx -g daemon off;"; cmdline != exp {
+		t.Errorf("expected %q, got %q", exp, cmdline)
+	}
+
+	args, err = (&Process{Pid: 2}).CmdlineSlice()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(args) != 0 {
+		t.Errorf("expected no args, got %q", args)
+	}
+}
+
+func TestWchan(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("1/wchan", "do_epoll_wait")
+	f.write("2/wchan", "0")
+	f.write("3/wchan", "")
+
+	cases := []struct {
+		pid   int32
+		wchan string
+	}{
+		{1, "do_epoll_wait"},
+		{2, ""},
+		{3, ""},
+	}
+	for _, tc := range cases {
+		wchan, err := (&Process{Pid: tc.pid}).Wchan()
+		if err != nil {
+			t.Errorf("pid %d: unexpected error: %v", tc.pid, err)
+			continue
+		}
+		if wchan != tc.wchan {
+			t.Errorf("pid %d: expected %q, got %q", tc.pid, tc.wchan, wchan)
+		}
+	}
+
+	if _, err := (&Process{Pid: 4}).Wchan(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestProcFS(t *testing.T) {
+	// HOST_PROC points at neither root.
+	neither := newEmptyFixture(t)
+	defer neither.cleanup()
+	neither.setenv("HOST_PROC", neither.root)
+	a := newRootFixture(t)
+	defer a.cleanup()
+	a.add(fakeProc{pid: 10, name: "alpha"})
+	a.add(fakeProc{pid: 11, name: "alpha"})
+	b := newRootFixture(t)
+	defer b.cleanup()
+	b.add(fakeProc{pid: 20, name: "beta"})
+
+	scan := func(fs ProcFS, expPids []int32, expName string) error {
+		procs, err := fs.Processes(context.Background())
+		if err != nil {
+			return err
+		}
+		var pids []int32
+		for _, p := range procs {
+			pids = append(pids, p.Pid)
+			if name, err := p.Name(); err != nil || name != expName {
+				return fmt.Errorf("pid %d: expected name %q, got %q: %v", p.Pid, expName, name, err)
+			}
+			if _, err := p.CreateTime(); err != nil {
+				return fmt.Errorf("pid %d: %v", p.Pid, err)
+			}
+		}
+		if !reflect.DeepEqual(pids, expPids) {
+			return fmt.Errorf("expected pids %v, got %v", expPids, pids)
+		}
+		return nil
+	}
+
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() { errs <- scan(NewProcFS(a.root), []int32{10, 11}, "alpha") }()
+		go func() { errs <- scan(NewProcFS(b.root), []int32{20}, "beta") }()
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := NewProcFS(a.root).NewProcess(20); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+	p, err := NewProcFS(b.root).NewProcess(20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ppid, err := p.Ppid(); err != nil || ppid != 0 {
+		t.Errorf("expected ppid 0, got %d: %v", ppid, err)
+	}
+	if running, err := p.IsRunning(); err != nil || !running {
+		t.Errorf("expected pid 20 to be running in its own proc filesystem: %v", err)
+	}
+	if _, err := Processes(context.Background()); err == nil {
+		t.Errorf("expected the empty HOST_PROC to be rejected")
+	}
+}
+
+// failingReader fails every read, to show that nothing more was read.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read too far")
+}
+
+func TestScanStatusStopsEarly(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("Name:\tnginx\nState:\tS (sleeping)\n"), failingReader{})
+	fields, err := scanStatus(r, "Name")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := map[string]string{"Name": "nginx"}; !reflect.DeepEqual(fields, exp) {
+		t.Errorf("expected %v, got %v", exp, fields)
+	}
+}
+
+// benchmarkStatus is a realistically sized status file.
+const benchmarkStatus = `Name:	nginx
+Umask:	0022
+State:	S (sleeping)
+Tgid:	42
+Ngid:	0
+Pid:	42
+PPid:	1
+TracerPid:	0
+Uid:	1000	1000	1000	1000
+Gid:	1000	1000	1000	1000
+FDSize:	64
+Groups:	1000
+NStgid:	42
+NSpid:	42
+NSpgid:	42
+NSsid:	42
+VmPeak:	   10000 kB
+VmSize:	   10000 kB
+VmLck:	       0 kB
+VmPin:	       0 kB
+VmHWM:	    5000 kB
+VmRSS:	    5000 kB
+RssAnon:	    1000 kB
+RssFile:	    4000 kB
+RssShmem:	       0 kB
+VmData:	    1000 kB
+VmStk:	     132 kB
+VmExe:	    1000 kB
+VmLib:	    2000 kB
+VmPTE:	      60 kB
+VmSwap:	       0 kB
+HugetlbPages:	       0 kB
+CoreDumping:	0
+THP_enabled:	1
+Threads:	1
+SigQ:	0/63432
+SigPnd:	0000000000000000
+ShdPnd:	0000000000000000
+SigBlk:	0000000000000000
+SigIgn:	0000000000001000
+SigCgt:	0000000188004a03
+CapInh:	0000000000000000
+CapPrm:	0000000000000000
+CapEff:	0000000000000000
+CapBnd:	000001ffffffffff
+CapAmb:	0000000000000000
+NoNewPrivs:	0
+Seccomp:	0
+Seccomp_filters:	0
+Speculation_Store_Bypass:	thread vulnerable
+Cpus_allowed:	ff
+Cpus_allowed_list:	0-7
+Mems_allowed:	00000000,00000001
+Mems_allowed_list:	0
+voluntary_ctxt_switches:	100
+nonvoluntary_ctxt_switches:	10
+`
+
+func benchmarkFixture(b *testing.B) *Process {
+	f := newFixture(b)
+	defer f.cleanup()
+	f.write("42/status", benchmarkStatus)
+	return &Process{Pid: 42}
+}
+
+func BenchmarkStatusNameFullRead(b *testing.B) {
+	p := benchmarkFixture(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fields, err := p.StatusMap()
+		if err != nil || fields["Name"] != "nginx" {
+			b.Fatalf("unexpected result: %v, %v", fields["Name"], err)
+		}
+	}
+}
+
+func BenchmarkStatusNameStreaming(b *testing.B) {
+	p := benchmarkFixture(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		name, err := p.statusField("Name")
+		if err != nil || name != "nginx" {
+			b.Fatalf("unexpected result: %v, %v", name, err)
+		}
+	}
+}
+
+func benchmarkPids(b *testing.B, batch int) {
+	f := newFixture(b)
+	defer f.cleanup()
+	addPidDirs(b, f, 10000)
+	fs := NewProcFS(f.root).WithReadDirBatchSize(batch)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.Pids(context.Background()); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkPidsAllAtOnce(b *testing.B) { benchmarkPids(b, 0) }
+func BenchmarkPidsBatched(b *testing.B)   { benchmarkPids(b, 256) }
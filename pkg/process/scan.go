@@ -0,0 +1,234 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultScanWorkers is how many processes Scan inspects at once, unless told
+// otherwise.
+const DefaultScanWorkers = 8
+
+// Scan calls fn for every process, from up to workers goroutines at once, or
+// DefaultScanWorkers if workers is not positive.
+//
+// An error from fn for one process, typically because it exited part way
+// through, is collected in the returned map, keyed by PID, and the scan
+// carries on.  But if the proc filesystem itself can no longer be read, or
+// ctx is done, the remaining processes are abandoned and that error is
+// returned.
+func Scan(ctx context.Context, workers int, fn func(*Process) error) (map[int32]error, error) {
+	return ProcFS{}.Scan(ctx, workers, fn)
+}
+
+// Scan is the package-level Scan, for the processes in this proc filesystem.
+func (fs ProcFS) Scan(ctx context.Context, workers int, fn func(*Process) error) (map[int32]error, error) {
+	procs, err := fs.Processes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = DefaultScanWorkers
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	failed := map[int32]error{}
+	var fatal error
+	abort := func(err error) {
+		mu.Lock()
+		if fatal == nil {
+			fatal = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	work := make(chan *Process)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := fn(p)
+				if err == nil {
+					continue
+				}
+				if rootErr := fs.rootErr(); rootErr != nil {
+					abort(rootErr)
+					continue
+				}
+				countSkip(err)
+				mu.Lock()
+				failed[p.Pid] = err
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, p := range procs {
+		select {
+		case work <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if fatal != nil {
+		return failed, fatal
+	}
+	return failed, parent.Err()
+}
+
+// NamedProcess is a process and its name, as read by ProcessesWithNames.
+type NamedProcess struct {
+	Process *Process
+	// Name is the name of the process, as Name returns, or "" if it
+	// couldn't be read, typically because the process exited.
+	Name string
+}
+
+// ProcessesWithNames returns every process, in increasing PID order, with
+// its name already read, for callers which need the names of all of them.
+// The names are read by DefaultScanWorkers goroutines at once, as by Scan,
+// which, given more than one CPU, is faster than calling Name for each
+// process in turn.
+func ProcessesWithNames(ctx context.Context) ([]NamedProcess, error) {
+	return ProcFS{}.ProcessesWithNames(ctx)
+}
+
+// ProcessesWithNames is the package-level ProcessesWithNames, for the
+// processes in this proc filesystem.
+func (fs ProcFS) ProcessesWithNames(ctx context.Context) ([]NamedProcess, error) {
+	var mu sync.Mutex
+	var named []NamedProcess
+	_, err := fs.Scan(ctx, DefaultScanWorkers, func(p *Process) error {
+		// Even a process whose name can't be read is listed.
+		name, err := p.Name()
+		mu.Lock()
+		named = append(named, NamedProcess{Process: p, Name: name})
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].Process.Pid < named[j].Process.Pid })
+	return named, nil
+}
+
+// ProcessSnapshot is a summary of a process, as read by SnapshotAll.
+type ProcessSnapshot struct {
+	Pid  int32
+	Name string
+	Ppid int32
+	// State is the single-letter state, as returned by Status.
+	State string
+	// RSS is the resident set size in bytes, or 0 for a kernel thread,
+	// which has no memory of its own.
+	RSS uint64
+}
+
+// SnapshotAll returns a snapshot of every process, in increasing PID order,
+// for diagnostic dumps.  Processes are read as by Scan, and those which
+// can't be, typically because they exited, are left out.  If ctx is done
+// part way through, as when its deadline passes on a slow host, the
+// snapshots read so far are returned along with ctx.Err(), rather than
+// nothing.
+func SnapshotAll(ctx context.Context) ([]ProcessSnapshot, error) {
+	return ProcFS{}.SnapshotAll(ctx)
+}
+
+// SnapshotAll is the package-level SnapshotAll, for the processes in this
+// proc filesystem.
+func (fs ProcFS) SnapshotAll(ctx context.Context) ([]ProcessSnapshot, error) {
+	var mu sync.Mutex
+	var snaps []ProcessSnapshot
+	_, err := fs.Scan(ctx, DefaultScanWorkers, func(p *Process) error {
+		snap, err := p.snapshot()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		snaps = append(snaps, snap)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil && err != ctx.Err() {
+		return nil, err
+	}
+	// The workers have all returned, so snaps is no longer being added to.
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Pid < snaps[j].Pid })
+	return snaps, err
+}
+
+// snapshot reads the snapshot of p, reading its status only once.
+func (p *Process) snapshot() (ProcessSnapshot, error) {
+	name, err := p.Name()
+	if err != nil {
+		return ProcessSnapshot{}, err
+	}
+	fields, err := p.StatusMap()
+	if err != nil {
+		return ProcessSnapshot{}, err
+	}
+	snap := ProcessSnapshot{Pid: p.Pid, Name: name, State: fields["State"]}
+	if snap.State == "" {
+		return ProcessSnapshot{}, p.errorf("read status", "no State field")
+	}
+	snap.State = snap.State[:1]
+	ppid, err := strconv.ParseInt(fields["PPid"], 10, 32)
+	if err != nil {
+		return ProcessSnapshot{}, p.errorf("read status", "malformed PPid %q: %v", fields["PPid"], err)
+	}
+	snap.Ppid = int32(ppid)
+	if rss, ok := fields["VmRSS"]; ok {
+		kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(rss, "kB")), 10, 64)
+		if err != nil {
+			return ProcessSnapshot{}, p.errorf("read status", "malformed VmRSS %q: %v", rss, err)
+		}
+		snap.RSS = kb * 1024
+	}
+	return snap, nil
+}
+
+// rootErr returns an error if this proc filesystem can't be read at all any
+// more, e.g. because it was unmounted.
+func (fs ProcFS) rootErr() error {
+	root := fs.path()
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+	if !fs.skipCheck {
+		return checkProcFS(root)
+	}
+	return nil
+}
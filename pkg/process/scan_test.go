@@ -0,0 +1,243 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScanCollectsErrors(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 20; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker"})
+	}
+
+	var calls int32
+	failed, err := Scan(context.Background(), 4, func(p *Process) error {
+		atomic.AddInt32(&calls, 1)
+		if p.Pid%2 == 0 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 20 {
+		t.Errorf("expected every process to be scanned, got %d", calls)
+	}
+	if len(failed) != 10 {
+		t.Errorf("expected 10 per-process errors, got %v", failed)
+	}
+	for pid := range failed {
+		if pid%2 != 0 {
+			t.Errorf("unexpected error for pid %d", pid)
+		}
+	}
+}
+
+func TestScanAbortsWhenRootVanishes(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 20; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker"})
+	}
+
+	var calls int32
+	failed, err := Scan(context.Background(), 1, func(p *Process) error {
+		atomic.AddInt32(&calls, 1)
+		if err := os.RemoveAll(f.root); err != nil {
+			t.Errorf("failed to remove root: %v", err)
+		}
+		_, err := p.Name()
+		return err
+	})
+	if err == nil {
+		t.Fatalf("expected the scan to be aborted")
+	}
+	if calls != 1 {
+		t.Errorf("expected the scan to stop after the first process, got %d calls", calls)
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected the fatal error not to be collected, got %v", failed)
+	}
+}
+
+func TestScanCancelled(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "init"})
+	f.add(fakeProc{pid: 2, name: "worker"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := Scan(ctx, 1, func(p *Process) error {
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestProcessesWithNames(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 20; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker-" + strconv.Itoa(int(pid))})
+	}
+	// Exited, as far as reading its name goes.
+	if err := os.Remove(filepath.Join(f.root, "7", "status")); err != nil {
+		t.Fatal(err)
+	}
+
+	named, err := ProcessesWithNames(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(named) != 20 {
+		t.Fatalf("expected 20 processes, got %d", len(named))
+	}
+	for i, np := range named {
+		pid := int32(i + 1)
+		exp := "worker-" + strconv.Itoa(int(pid))
+		if pid == 7 {
+			exp = ""
+		}
+		if np.Process.Pid != pid || np.Name != exp {
+			t.Errorf("%d: expected pid %d named %q, got pid %d named %q", i, pid, exp, np.Process.Pid, np.Name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ProcessesWithNames(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSnapshotAll(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 1, name: "init"})
+	f.add(fakeProc{pid: 2, name: "kthreadd", ppid: 0})
+	f.add(fakeProc{pid: 42, name: "nginx", state: "R", ppid: 1})
+	f.add(fakeProc{pid: 43, name: "gone", ppid: 1})
+	status, err := (&Process{Pid: 42}).ReadProcFile("status")
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	f.write("42/status", string(status)+"VmRSS:\t    2048 kB\n")
+	if err := os.Remove(filepath.Join(f.root, "43", "status")); err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err := SnapshotAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []ProcessSnapshot{
+		{Pid: 1, Name: "init", State: "S"},
+		{Pid: 2, Name: "kthreadd", State: "S"},
+		{Pid: 42, Name: "nginx", Ppid: 1, State: "R", RSS: 2 << 20},
+	}
+	if !reflect.DeepEqual(snaps, exp) {
+		t.Errorf("expected %+v, got %+v", exp, snaps)
+	}
+}
+
+func TestSnapshotAllDeadline(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	const n = 500
+	for pid := int32(1); pid <= n; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker", ppid: 1})
+	}
+
+	// How far a scan gets before its deadline depends on the machine, so
+	// keep doubling the deadline until one passes part way through.
+	partial := false
+	for timeout := time.Millisecond; timeout < 10*time.Second && !partial; timeout *= 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		snaps, err := SnapshotAll(ctx)
+		cancel()
+		if err == nil {
+			if len(snaps) != n {
+				t.Fatalf("%v: expected all %d processes, got %d", timeout, n, len(snaps))
+			}
+			continue
+		}
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%v: expected context.DeadlineExceeded, got %v", timeout, err)
+		}
+		if len(snaps) == 0 || len(snaps) == n {
+			continue
+		}
+		partial = true
+		for i, snap := range snaps {
+			if snap.Name != "worker" || snap.Ppid != 1 || (i > 0 && snap.Pid <= snaps[i-1].Pid) {
+				t.Fatalf("%v: unexpected snapshot %d: %+v", timeout, i, snap)
+			}
+		}
+	}
+	if !partial {
+		t.Errorf("expected some deadline to pass part way through the scan")
+	}
+}
+
+func benchmarkNames(b *testing.B, names func() error) {
+	f := newFixture(b)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 1000; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker"})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := names(); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkNamesSerial(b *testing.B) {
+	benchmarkNames(b, func() error {
+		procs, err := Processes(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, p := range procs {
+			p.Name()
+		}
+		return nil
+	})
+}
+
+func BenchmarkProcessesWithNames(b *testing.B) {
+	benchmarkNames(b, func() error {
+		_, err := ProcessesWithNames(context.Background())
+		return err
+	})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// SequenceStep is one step of SignalSequence.
+type SequenceStep struct {
+	// Name is the name of the processes to signal.
+	Name string
+	// Signal is the signal to send them.
+	Signal Signal
+	// WaitExit makes the step wait for the signaled processes to exit, or
+	// become zombies, before the next step starts.
+	WaitExit bool
+	// Timeout bounds the wait, if WaitExit is set.  Zero means no limit
+	// other than the context.
+	Timeout time.Duration
+}
+
+// StepResult is the outcome of one step of SignalSequence.
+type StepResult struct {
+	// Signaled holds the PIDs of the processes signaled, in PID order.
+	Signaled []int32
+	// Err is why the step failed, or nil.
+	Err error
+}
+
+// SignalSequence runs steps in order, for tearing down a set of processes
+// which depend on each other.  It stops at the first step which fails,
+// including one whose processes don't exit in time, and returns the results
+// of the steps run so far along with that step's error.  A step which
+// matches no processes succeeds.
+func SignalSequence(ctx context.Context, steps []SequenceStep) ([]StepResult, error) {
+	return ProcFS{}.SignalSequence(ctx, steps)
+}
+
+// SignalSequence is the package-level SignalSequence, for the processes in
+// this proc filesystem, waiting by its clock.
+func (fs ProcFS) SignalSequence(ctx context.Context, steps []SequenceStep) ([]StepResult, error) {
+	for i, step := range steps {
+		if err := step.Signal.valid(); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i, err)
+		}
+	}
+
+	var results []StepResult
+	for i, step := range steps {
+		res := fs.runStep(ctx, step)
+		results = append(results, res)
+		if res.Err != nil {
+			return results, fmt.Errorf("step %d (%s %s): %w", i, step.Signal, step.Name, res.Err)
+		}
+	}
+	return results, nil
+}
+
+// runStep signals the processes named by step and, if asked to, waits for
+// them to exit.
+func (fs ProcFS) runStep(ctx context.Context, step SequenceStep) StepResult {
+	procs, err := fs.processesByName(ctx, step.Name)
+	if err != nil {
+		return StepResult{Err: err}
+	}
+	// Read start times first, so that a PID reused after the signal is
+	// seen as an exit.
+	for _, p := range procs {
+		p.CreateTime()
+	}
+	signaled, err := signalAll(ctx, procs, syscall.Signal(step.Signal))
+	res := StepResult{Signaled: signaled, Err: err}
+	if err != nil || !step.WaitExit {
+		return res
+	}
+
+	c := clockOr(fs.clock)
+	var expired <-chan time.Time
+	if step.Timeout > 0 {
+		expired = c.After(step.Timeout)
+	}
+	for _, p := range procs {
+		if err := p.waitForExit(ctx, c, DefaultPollInterval, true, expired); err != nil {
+			if err == errExpired {
+				err = fmt.Errorf("pid %d did not exit within %v", p.Pid, step.Timeout)
+			}
+			res.Err = err
+			return res
+		}
+	}
+	return res
+}
+
+// LadderStep is one step of SignalLadder.
+type LadderStep struct {
+	// Signal is the signal to send.
+	Signal Signal
+	// Wait is how long to wait for the processes to exit before going on to
+	// the next step.
+	Wait time.Duration
+}
+
+// SignalLadder sends the signals of steps in turn to the processes named
+// name, as found when it is called, waiting up to each step's Wait for them
+// to exit before escalating to the next, e.g. from SIGTERM to SIGINT to
+// SIGKILL.  Each step only signals those which are still running, and the
+// ladder stops as soon as they have all exited or become zombies.  It
+// returns the results of the steps run, as SignalSequence does.  Processes
+// still running after the last step's Wait are an error, unless that Wait is
+// zero, in which case SignalLadder returns once the last signal is sent.
+func SignalLadder(ctx context.Context, name string, steps []LadderStep) ([]StepResult, error) {
+	return ProcFS{}.SignalLadder(ctx, name, steps)
+}
+
+// SignalLadder is the package-level SignalLadder, for the processes in this
+// proc filesystem, waiting by its clock.
+func (fs ProcFS) SignalLadder(ctx context.Context, name string, steps []LadderStep) ([]StepResult, error) {
+	if len(steps) == 0 {
+		return nil, errors.New("no steps")
+	}
+	for i, step := range steps {
+		if err := step.Signal.valid(); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i, err)
+		}
+		if step.Wait < 0 {
+			return nil, fmt.Errorf("step %d: invalid wait %v", i, step.Wait)
+		}
+	}
+
+	procs, err := fs.processesByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// Read start times first, so that a PID reused after a signal is seen as
+	// an exit, rather than signaled by the next step.
+	for _, p := range procs {
+		p.CreateTime()
+	}
+	var results []StepResult
+	for i, step := range steps {
+		if len(procs) == 0 {
+			break
+		}
+		signaled, err := signalAll(ctx, procs, syscall.Signal(step.Signal))
+		results = append(results, StepResult{Signaled: signaled, Err: err})
+		if err != nil {
+			return results, fmt.Errorf("step %d (%s %s): %w", i, step.Signal, name, err)
+		}
+		running := signaled
+		if step.Wait > 0 {
+			if running, err = stillAlive(ctx, clockOr(fs.clock), procs, signaled, step.Wait); err != nil {
+				return results, err
+			}
+		}
+		procs = withPids(procs, running)
+	}
+	if last := steps[len(steps)-1]; len(procs) > 0 && last.Wait > 0 {
+		var pids []int32
+		for _, p := range procs {
+			pids = append(pids, p.Pid)
+		}
+		return results, fmt.Errorf("pids %v did not exit within %v of %s", pids, last.Wait, last.Signal)
+	}
+	return results, nil
+}
+
+// withPids returns those of procs whose PIDs are in pids.
+func withPids(procs []*Process, pids []int32) []*Process {
+	wanted := make(map[int32]bool, len(pids))
+	for _, pid := range pids {
+		wanted[pid] = true
+	}
+	var kept []*Process
+	for _, p := range procs {
+		if wanted[p.Pid] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalSequence(t *testing.T) {
+	first, second, third := uniqueName("sq1"), uniqueName("sq2"), uniqueName("sq3")
+	a := startNamed(t, first)
+	defer stopNamed(a)
+	b := startNamed(t, second)
+	defer stopNamed(b)
+	c := startNamed(t, second)
+	defer stopNamed(c)
+	d := startNamed(t, third)
+	defer stopNamed(d)
+
+	results, err := SignalSequence(context.Background(), []SequenceStep{
+		{Name: first, Signal: Signal(syscall.SIGTERM), WaitExit: true, Timeout: time.Minute},
+		{Name: second, Signal: Signal(syscall.SIGINT), WaitExit: true},
+		{Name: uniqueName("sqnone"), Signal: Signal(syscall.SIGTERM), WaitExit: true},
+		{Name: third, Signal: Signal(syscall.SIGKILL)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pid := func(cmd *exec.Cmd) int32 { return int32(cmd.Process.Pid) }
+	low, high := pid(b), pid(c)
+	if low > high {
+		low, high = high, low
+	}
+	expected := [][]int32{{pid(a)}, {low, high}, nil, {pid(d)}}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Errorf("step %d: unexpected error: %v", i, res.Err)
+		}
+		if !reflect.DeepEqual(res.Signaled, expected[i]) {
+			t.Errorf("step %d: expected %v signaled, got %v", i, expected[i], res.Signaled)
+		}
+	}
+
+	for cmd, sig := range map[*exec.Cmd]syscall.Signal{a: syscall.SIGTERM, b: syscall.SIGINT, c: syscall.SIGINT, d: syscall.SIGKILL} {
+		if got := exitSignal(t, cmd); got != sig {
+			t.Errorf("expected pid %d to get %v, got %v", cmd.Process.Pid, sig, got)
+		}
+	}
+}
+
+func TestSignalSequenceTimeout(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "stubborn", start: 100})
+	f.add(fakeProc{pid: 43, name: "after", start: 100})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	results, err := SignalSequence(context.Background(), []SequenceStep{
+		{Name: "stubborn", Signal: Signal(syscall.SIGTERM), WaitExit: true, Timeout: 50 * time.Millisecond},
+		{Name: "after", Signal: Signal(syscall.SIGTERM)},
+	})
+	if err == nil || !strings.Contains(err.Error(), "did not exit") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected only the failed step's result, got %+v", results)
+	}
+	if pids := rec.signaled(); !reflect.DeepEqual(pids, []int32{42}) {
+		t.Errorf("expected only pid 42 to be signaled, got %v", pids)
+	}
+}
+
+func TestSignalSequenceInvalidSignal(t *testing.T) {
+	rec := recordSignals(t)
+	defer rec.restore()
+	_, err := SignalSequence(context.Background(), []SequenceStep{
+		{Name: uniqueName("sqinv"), Signal: Signal(syscall.SIGTERM)},
+		{Name: uniqueName("sqinv"), Signal: Signal(0)},
+	})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if pids := rec.signaled(); len(pids) != 0 {
+		t.Errorf("expected nothing to be signaled, got %v", pids)
+	}
+}
+
+// ladderRun is a SignalLadder running against fixture processes which exit
+// on particular signals.
+type ladderRun struct {
+	mu   sync.Mutex
+	sent []ladderSignal
+	// results and err are what SignalLadder returned.
+	results []StepResult
+	err     error
+}
+
+// ladderSignal is a signal sent by a ladderRun, and when.
+type ladderSignal struct {
+	pid int32
+	sig syscall.Signal
+	at  time.Time
+}
+
+// runLadder runs SignalLadder for the processes in f named "server", where
+// each of diesOn is the signal which makes the process with that PID exit.
+// It moves clock along until the ladder returns.
+func runLadder(t *testing.T, f *fixture, clock *fakeClock, diesOn map[int32]syscall.Signal, steps []LadderStep) *ladderRun {
+	t.Helper()
+	r := &ladderRun{}
+	orig := sendSignal
+	sendSignal = func(p *Process, ctx context.Context, sig syscall.Signal) error {
+		r.mu.Lock()
+		r.sent = append(r.sent, ladderSignal{p.Pid, sig, clock.Now()})
+		r.mu.Unlock()
+		if diesOn[p.Pid] == sig {
+			return os.RemoveAll(filepath.Join(f.root, strconv.Itoa(int(p.Pid))))
+		}
+		return nil
+	}
+	defer func() { sendSignal = orig }()
+
+	done := make(chan struct{})
+	go func() {
+		r.results, r.err = ProcFS{}.WithClock(clock).SignalLadder(context.Background(), "server", steps)
+		close(done)
+	}()
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		select {
+		case <-done:
+			return r
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ladder did not finish")
+		}
+		clock.Advance(DefaultPollInterval)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSignalLadder(t *testing.T) {
+	steps := []LadderStep{
+		{Signal: Signal(syscall.SIGTERM), Wait: 10 * time.Second},
+		{Signal: Signal(syscall.SIGINT), Wait: 10 * time.Second},
+		{Signal: Signal(syscall.SIGKILL), Wait: 10 * time.Second},
+	}
+	signaled := func(results []StepResult) [][]int32 {
+		var pids [][]int32
+		for _, res := range results {
+			pids = append(pids, res.Signaled)
+		}
+		return pids
+	}
+
+	t.Run("early exit", func(t *testing.T) {
+		f := newFixture(t)
+		defer f.cleanup()
+		f.add(fakeProc{pid: 10, name: "server", start: 100})
+		f.add(fakeProc{pid: 11, name: "server", start: 100})
+		clock := newFakeClock()
+		r := runLadder(t, f, clock, map[int32]syscall.Signal{10: syscall.SIGTERM, 11: syscall.SIGTERM}, steps)
+		if err := r.err; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp := [][]int32{{10, 11}}; !reflect.DeepEqual(signaled(r.results), exp) {
+			t.Errorf("expected only the first step, signaling %v, got %v", exp, signaled(r.results))
+		}
+	})
+
+	t.Run("full escalation", func(t *testing.T) {
+		f := newFixture(t)
+		defer f.cleanup()
+		f.add(fakeProc{pid: 10, name: "server", start: 100})
+		f.add(fakeProc{pid: 11, name: "server", start: 100})
+		f.add(fakeProc{pid: 12, name: "other", start: 100})
+		clock := newFakeClock()
+		start := clock.Now()
+		r := runLadder(t, f, clock, map[int32]syscall.Signal{10: syscall.SIGTERM, 11: syscall.SIGKILL}, steps)
+		if err := r.err; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp := [][]int32{{10, 11}, {11}, {11}}; !reflect.DeepEqual(signaled(r.results), exp) {
+			t.Errorf("expected %v signaled, got %v", exp, signaled(r.results))
+		}
+		// Each escalation waits out the step before.
+		exp := []syscall.Signal{syscall.SIGTERM, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL}
+		if len(r.sent) != len(exp) {
+			t.Fatalf("expected %d signals, got %+v", len(exp), r.sent)
+		}
+		for i, s := range r.sent {
+			if s.sig != exp[i] {
+				t.Errorf("signal %d: expected %v, got %v", i, exp[i], s.sig)
+			}
+		}
+		for i, min := range []time.Duration{0, 0, 10 * time.Second, 20 * time.Second} {
+			if elapsed := r.sent[i].at.Sub(start); elapsed < min {
+				t.Errorf("signal %d: expected it to wait at least %v, sent after %v", i, min, elapsed)
+			}
+		}
+	})
+
+	t.Run("survives", func(t *testing.T) {
+		f := newFixture(t)
+		defer f.cleanup()
+		f.add(fakeProc{pid: 10, name: "server", start: 100})
+		clock := newFakeClock()
+		r := runLadder(t, f, clock, nil, steps)
+		if err := r.err; err == nil || !strings.Contains(err.Error(), "did not exit") {
+			t.Fatalf("expected an error, got %v", err)
+		}
+		if len(r.results) != 3 {
+			t.Errorf("expected every step to run, got %+v", r.results)
+		}
+	})
+}
+
+func TestSignalLadderInvalid(t *testing.T) {
+	rec := recordSignals(t)
+	defer rec.restore()
+	for _, steps := range [][]LadderStep{
+		nil,
+		{{Signal: Signal(syscall.SIGTERM), Wait: time.Second}, {Signal: Signal(0)}},
+		{{Signal: Signal(syscall.SIGTERM), Wait: -time.Second}},
+	} {
+		if _, err := SignalLadder(context.Background(), uniqueName("ladinv"), steps); err == nil {
+			t.Errorf("%+v: expected an error", steps)
+		}
+	}
+	if pids := rec.signaled(); len(pids) != 0 {
+		t.Errorf("expected nothing to be signaled, got %v", pids)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SendSignal sends sig to the process.
+func (p *Process) SendSignal(sig syscall.Signal) error {
+	return p.SendSignalWithContext(context.Background(), sig)
+}
+
+// maxSignalAttempts is how many times SendSignalWithContext tries to send a
+// signal which keeps being interrupted.
+const maxSignalAttempts = 5
+
+// SendSignalWithContext sends sig to the process, unless ctx is already done,
+// in which case it returns ctx.Err().  If sending is interrupted by a signal
+// to the caller, it is retried a few times, as long as ctx isn't done.
+// Other failures, such as the process not existing (ESRCH) or not being
+// ours to signal (EPERM), are returned straight away.
+func (p *Process) SendSignalWithContext(ctx context.Context, sig syscall.Signal) error {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := kill(p.Pid, sig)
+		if !errors.Is(err, syscall.EINTR) || attempt == maxSignalAttempts {
+			return err
+		}
+	}
+}
+
+// kill sends sig to pid, or to the process group -pid if pid is negative, as
+// kill(2) does.  Tests replace it to inject failures.
+var kill = func(pid int32, sig syscall.Signal) error {
+	return syscall.Kill(int(pid), sig)
+}
+
+// Signal is a signal which can be sent to a process.  Use ParseSignal or
+// SignalFromInt to get one from user input, so that it is validated.
+type Signal int
+
+// signalNames holds every signal which may be sent.  It is built from the
+// syscall constants, which differ between architectures.
+var signalNames = map[Signal]string{
+	Signal(syscall.SIGABRT):   "SIGABRT",
+	Signal(syscall.SIGALRM):   "SIGALRM",
+	Signal(syscall.SIGBUS):    "SIGBUS",
+	Signal(syscall.SIGCHLD):   "SIGCHLD",
+	Signal(syscall.SIGCONT):   "SIGCONT",
+	Signal(syscall.SIGFPE):    "SIGFPE",
+	Signal(syscall.SIGHUP):    "SIGHUP",
+	Signal(syscall.SIGILL):    "SIGILL",
+	Signal(syscall.SIGINT):    "SIGINT",
+	Signal(syscall.SIGIO):     "SIGIO",
+	Signal(syscall.SIGKILL):   "SIGKILL",
+	Signal(syscall.SIGPIPE):   "SIGPIPE",
+	Signal(syscall.SIGPROF):   "SIGPROF",
+	Signal(syscall.SIGPWR):    "SIGPWR",
+	Signal(syscall.SIGQUIT):   "SIGQUIT",
+	Signal(syscall.SIGSEGV):   "SIGSEGV",
+	Signal(syscall.SIGSTOP):   "SIGSTOP",
+	Signal(syscall.SIGSYS):    "SIGSYS",
+	Signal(syscall.SIGTERM):   "SIGTERM",
+	Signal(syscall.SIGTRAP):   "SIGTRAP",
+	Signal(syscall.SIGTSTP):   "SIGTSTP",
+	Signal(syscall.SIGTTIN):   "SIGTTIN",
+	Signal(syscall.SIGTTOU):   "SIGTTOU",
+	Signal(syscall.SIGURG):    "SIGURG",
+	Signal(syscall.SIGUSR1):   "SIGUSR1",
+	Signal(syscall.SIGUSR2):   "SIGUSR2",
+	Signal(syscall.SIGVTALRM): "SIGVTALRM",
+	Signal(syscall.SIGWINCH):  "SIGWINCH",
+	Signal(syscall.SIGXCPU):   "SIGXCPU",
+	Signal(syscall.SIGXFSZ):   "SIGXFSZ",
+}
+
+// String returns the name of the signal, e.g. "SIGHUP".
+func (s Signal) String() string {
+	if name, ok := signalNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Signal(%d)", int(s))
+}
+
+// valid returns an error if s is not a known signal.
+func (s Signal) valid() error {
+	if _, ok := signalNames[s]; !ok {
+		return fmt.Errorf("invalid signal number %d", int(s))
+	}
+	return nil
+}
+
+// terminatesByDefault returns true if the default action of s, for a process
+// which neither handles nor ignores it, is to terminate, with or without a
+// core dump.  The others are ignored, or stop or continue the process.
+func (s Signal) terminatesByDefault() bool {
+	switch syscall.Signal(s) {
+	case syscall.SIGCHLD, syscall.SIGCONT, syscall.SIGSTOP, syscall.SIGTSTP,
+		syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGURG, syscall.SIGWINCH:
+		return false
+	}
+	return s.valid() == nil
+}
+
+// ParseSignal returns the signal named by s, which may be a name with or
+// without the "SIG" prefix, in any case, or a number.
+func ParseSignal(s string) (Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return SignalFromInt(n)
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for sig, n := range signalNames {
+		if n == name {
+			return sig, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// SignalFromInt returns n as a Signal, or an error if it is not a known
+// signal number.
+func SignalFromInt(n int) (Signal, error) {
+	sig := Signal(n)
+	if err := sig.valid(); err != nil {
+		return 0, err
+	}
+	return sig, nil
+}
+
+// SignalProcs sends sig to every process named name, and returns the number
+// of processes signaled.  Processes which exit before they can be signaled
+// are not counted and are not errors.
+func SignalProcs(name string, sig Signal) (int, error) {
+	return SignalProcsBy(func(p *Process) (bool, error) {
+		n, err := p.Name()
+		return n == name, err
+	}, sig)
+}
+
+// SignalProcsBy sends sig to every process for which match returns true, and
+// returns the number of processes signaled.  A process for which match
+// returns an error, typically because it exited while being inspected, is
+// skipped.  The other SignalProcs variants are built on this, and it can
+// combine their criteria, or any others.
+func SignalProcsBy(match func(*Process) (bool, error), sig Signal) (int, error) {
+	return SignalProcsByWithContext(context.Background(), match, sig, SkipMatchErrors)
+}
+
+// MatchErrorPolicy says what SignalProcsByWithContext does with a process for
+// which match returns an error.
+type MatchErrorPolicy int
+
+const (
+	// SkipMatchErrors skips the process, whatever the error.
+	SkipMatchErrors MatchErrorPolicy = iota
+	// AbortOnMatchError skips the process if it has exited, that is, if the
+	// error matches ErrProcessNotFound, but otherwise stops the scan and
+	// returns the error without signaling anything.
+	AbortOnMatchError
+)
+
+// SignalProcsByWithContext is SignalProcsBy, which stops early if ctx is done
+// and handles errors from match according to policy.
+func SignalProcsByWithContext(ctx context.Context, match func(*Process) (bool, error), sig Signal, policy MatchErrorPolicy) (int, error) {
+	if err := sig.valid(); err != nil {
+		return 0, err
+	}
+	if policy < SkipMatchErrors || policy > AbortOnMatchError {
+		return 0, fmt.Errorf("invalid match error policy %d", int(policy))
+	}
+	procs, err := ProcFS{}.processesMatchingWithPolicy(ctx, match, policy)
+	if err != nil {
+		return 0, err
+	}
+	signaled, err := signalAll(ctx, procs, syscall.Signal(sig))
+	return len(signaled), err
+}
+
+// SignalProcsInt is SignalProcs for a raw signal number, which is validated
+// before use.
+func SignalProcsInt(name string, signal int) (int, error) {
+	sig, err := SignalFromInt(signal)
+	if err != nil {
+		return 0, err
+	}
+	return SignalProcs(name, sig)
+}
+
+// SignalProcsByArg sends sig to every process whose argument at index, where
+// 0 is the program, is exactly value, and returns the number of processes
+// signaled.  Processes with too few arguments don't match.
+func SignalProcsByArg(index int, value string, sig Signal) (int, error) {
+	if index < 0 {
+		return 0, fmt.Errorf("invalid argument index %d", index)
+	}
+	return SignalProcsBy(func(p *Process) (bool, error) {
+		args, truncated, err := p.CmdlineSliceWithLimit(p.fs.cmdlineLimit())
+		if err != nil || index >= len(args) {
+			return false, err
+		}
+		// The last argument of a truncated command line may be cut short.
+		if truncated && index == len(args)-1 {
+			return false, nil
+		}
+		return args[index] == value, nil
+	}, sig)
+}
+
+// SignalProcsGlob sends sig to every process whose name matches pattern, a
+// glob like "myapp-*" with the syntax of path.Match, and returns the number
+// of processes signaled.
+func SignalProcsGlob(pattern string, sig Signal) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return SignalProcsBy(func(p *Process) (bool, error) {
+		name, err := p.Name()
+		if err != nil {
+			return false, err
+		}
+		return path.Match(pattern, name)
+	}, sig)
+}
+
+// SignalProcsInDir sends sig to every process whose working directory is
+// dir or somewhere under it, such as programs running in a synced worktree,
+// and returns the number of processes signaled.  dir is as we see it, and the
+// working directories, which the kernel reports as the host sees them, are
+// compared with it through HostPath.  dir may be a symlink, like git-sync's
+// --link, and is resolved first, since the kernel reports working
+// directories with symlinks resolved.  Processes whose working directory
+// can't be read are skipped.
+func SignalProcsInDir(dir string, sig Signal) (int, error) {
+	if !filepath.IsAbs(dir) {
+		return 0, fmt.Errorf("invalid directory %q: must be absolute", dir)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return 0, err
+	}
+	return SignalProcsBy(func(p *Process) (bool, error) {
+		cwd, err := p.Cwd()
+		if err != nil {
+			return false, err
+		}
+		return inDir(p.HostPath(cwd), resolved), nil
+	}, sig)
+}
+
+// inDir returns true if path is dir or is under it.  Both must be clean.
+func inDir(path, dir string) bool {
+	if dir == "/" {
+		return true
+	}
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// sendSignal delivers a signal to a process.  Tests replace it so that they
+// can signal processes in a fake proc filesystem.
+var sendSignal = (*Process).SendSignalWithContext
+
+// signalAll sends sig to each of procs, in order, and returns the PIDs of the
+// processes signaled.  A PID which appears more than once, as when procs
+// combines the matches of several criteria, is only signaled the first time.
+// That holds even if the entries have different start times, since a signal
+// goes to whichever process has the PID now.  Failures other than the process
+// having exited are collected into the returned error.
+func signalAll(ctx context.Context, procs []*Process, sig syscall.Signal) ([]int32, error) {
+	signaled, _, err := signalEach(ctx, procs, sig, false)
+	return signaled, err
+}
+
+// signalEach is signalAll, but if skipDenied is set, processes which we lack
+// permission to signal are returned in denied rather than as errors.
+func signalEach(ctx context.Context, procs []*Process, sig syscall.Signal, skipDenied bool) (signaled, denied []int32, err error) {
+	var errs []string
+	seen := make(map[int32]struct{}, len(procs))
+	for _, p := range procs {
+		if _, ok := seen[p.Pid]; ok {
+			continue
+		}
+		seen[p.Pid] = struct{}{}
+		if err := sendSignal(p, ctx, sig); err != nil {
+			if isProcessGone(err) {
+				continue
+			}
+			if skipDenied && errors.Is(err, syscall.EPERM) {
+				denied = append(denied, p.Pid)
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("pid %d: %v", p.Pid, explainDenied(p, err)))
+			continue
+		}
+		signaled = append(signaled, p.Pid)
+	}
+	if len(errs) > 0 {
+		return signaled, denied, fmt.Errorf("failed to send %v: %s", sig, strings.Join(errs, "; "))
+	}
+	return signaled, denied, nil
+}
+
+// explainDenied adds to err, a failure to signal p, the reason we may not
+// signal it, if it was refused and the user IDs involved explain why.
+func explainDenied(p *Process, err error) error {
+	if !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+	creds := ownCredentials()
+	real, _, saved, uerr := p.Uids()
+	if uerr != nil || creds.mayKill(real, saved) {
+		return err
+	}
+	return fmt.Errorf("%v: running as uid %d (effective %d) without CAP_KILL, but the process belongs to uid %d", err, creds.real, creds.effective, real)
+}
+
+// isProcessGone returns true if err indicates that the signaled process no
+// longer exists.
+func isProcessGone(err error) bool {
+	return errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,592 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// uniqueName returns a process name which no other process should have.  It
+// is short enough to not be truncated by the kernel.
+func uniqueName(suffix string) string {
+	return "gs" + strconv.Itoa(os.Getpid()) + suffix
+}
+
+// startNamed starts a long-running child process named name.  The name comes
+// from a symlink to sleep, since the kernel names a process after the file
+// it was exec'ed from.  Callers stop it with stopNamed.
+func startNamed(t *testing.T, name string) *exec.Cmd {
+	t.Helper()
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skipf("can't find sleep: %v", err)
+	}
+	dir, remove := tempDir(t)
+	bin := filepath.Join(dir, name)
+	if err := os.Symlink(sleep, bin); err != nil {
+		remove()
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	cmd := exec.Command(bin, "1000")
+	if err := cmd.Start(); err != nil {
+		remove()
+		t.Fatalf("failed to start %s: %v", name, err)
+	}
+	return cmd
+}
+
+// stopNamed kills cmd, started by startNamed, if it is still running, and
+// removes the link it was exec'ed from.
+func stopNamed(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+	cmd.Wait()
+	os.RemoveAll(filepath.Dir(cmd.Path))
+}
+
+// exitSignal returns the signal which terminated cmd, after waiting for it.
+func exitSignal(t *testing.T, cmd *exec.Cmd) syscall.Signal {
+	t.Helper()
+	cmd.Wait()
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		t.Fatalf("expected pid %d to be killed by a signal, got %v", cmd.Process.Pid, cmd.ProcessState)
+	}
+	return ws.Signal()
+}
+
+func TestParseSignal(t *testing.T) {
+	cases := []struct {
+		in  string
+		sig Signal
+	}{
+		{"SIGHUP", Signal(syscall.SIGHUP)},
+		{"HUP", Signal(syscall.SIGHUP)},
+		{"sighup", Signal(syscall.SIGHUP)},
+		{"usr1", Signal(syscall.SIGUSR1)},
+		{"15", Signal(syscall.SIGTERM)},
+	}
+	for _, tc := range cases {
+		sig, err := ParseSignal(tc.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if sig != tc.sig {
+			t.Errorf("%q: expected %v, got %v", tc.in, tc.sig, sig)
+		}
+	}
+
+	for _, in := range []string{"", "SIG", "SIGFOO", "0", "-1", "32", "1000", "1.5"} {
+		if sig, err := ParseSignal(in); err == nil {
+			t.Errorf("%q: expected an error, got %v", in, sig)
+		}
+	}
+}
+
+func TestSignalRoundTrip(t *testing.T) {
+	for sig := range signalNames {
+		parsed, err := ParseSignal(sig.String())
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", sig, err)
+			continue
+		}
+		if parsed != sig {
+			t.Errorf("%v: round-tripped to %v", sig, parsed)
+		}
+	}
+	if s := Signal(-1).String(); s != "Signal(-1)" {
+		t.Errorf("expected invalid signal to be formatted as a number, got %q", s)
+	}
+}
+
+func TestSignalTerminatesByDefault(t *testing.T) {
+	for sig, exp := range map[syscall.Signal]bool{
+		syscall.SIGHUP:   true,
+		syscall.SIGUSR1:  true,
+		syscall.SIGTERM:  true,
+		syscall.SIGQUIT:  true,
+		syscall.SIGKILL:  true,
+		syscall.SIGWINCH: false,
+		syscall.SIGCHLD:  false,
+		syscall.SIGCONT:  false,
+		syscall.SIGTSTP:  false,
+	} {
+		if got := Signal(sig).terminatesByDefault(); got != exp {
+			t.Errorf("%v: expected %v, got %v", sig, exp, got)
+		}
+	}
+	if Signal(0).terminatesByDefault() {
+		t.Errorf("expected an invalid signal not to terminate")
+	}
+}
+
+func TestSignalProcsInvalidSignal(t *testing.T) {
+	for _, n := range []int{-1, 0, 65} {
+		if _, err := SignalProcsInt(uniqueName("inv"), n); err == nil {
+			t.Errorf("%d: expected an error", n)
+		}
+		if _, err := SignalProcs(uniqueName("inv"), Signal(n)); err == nil {
+			t.Errorf("%d: expected an error", n)
+		}
+	}
+}
+
+func TestSignalProcs(t *testing.T) {
+	name := uniqueName("sig")
+	a := startNamed(t, name)
+	defer stopNamed(a)
+	b := startNamed(t, name)
+	defer stopNamed(b)
+	other := startNamed(t, uniqueName("oth"))
+	defer stopNamed(other)
+
+	n, err := SignalProcs(name, Signal(syscall.SIGTERM))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 processes signaled, got %d", n)
+	}
+	for _, cmd := range []*exec.Cmd{a, b} {
+		if sig := exitSignal(t, cmd); sig != syscall.SIGTERM {
+			t.Errorf("expected pid %d to get SIGTERM, got %v", cmd.Process.Pid, sig)
+		}
+	}
+	if running, err := (&Process{Pid: int32(other.Process.Pid)}).IsRunning(); err != nil || !running {
+		t.Errorf("expected unrelated pid %d to be running: %v", other.Process.Pid, err)
+	}
+}
+
+func TestSendSignalWithContextCancelled(t *testing.T) {
+	cmd := startNamed(t, uniqueName("ctx"))
+	defer stopNamed(cmd)
+	p := &Process{Pid: int32(cmd.Process.Pid)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.SendSignalWithContext(ctx, syscall.SIGTERM); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Give a signal, had it been sent, time to be delivered.
+	time.Sleep(50 * time.Millisecond)
+	if running, err := p.IsRunning(); err != nil || !running {
+		t.Fatalf("expected pid %d to still be running: %v", p.Pid, err)
+	}
+	if state, err := p.Status(); err != nil || state == "Z" {
+		t.Fatalf("expected pid %d to not have exited, got state %q: %v", p.Pid, state, err)
+	}
+
+	if err := p.SendSignalWithContext(context.Background(), syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sig := exitSignal(t, cmd); sig != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM, got %v", sig)
+	}
+}
+
+func TestSignalProcsNoMatch(t *testing.T) {
+	n, err := SignalProcs(uniqueName("none"), Signal(syscall.SIGTERM))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no processes signaled, got %d", n)
+	}
+}
+
+func TestSendSignalRetriesEINTR(t *testing.T) {
+	var calls int
+	failures := 0
+	orig := kill
+	kill = func(pid int32, sig syscall.Signal) error {
+		calls++
+		if calls <= failures {
+			return os.NewSyscallError("kill", syscall.EINTR)
+		}
+		return nil
+	}
+	defer func() { kill = orig }()
+	p := &Process{Pid: 42}
+
+	// Interrupted, then delivered.
+	calls, failures = 0, 2
+	if err := p.SendSignal(syscall.SIGHUP); err != nil {
+		t.Errorf("expected the retry to succeed, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+
+	// Interrupted every time.
+	calls, failures = 0, 100
+	if err := p.SendSignal(syscall.SIGHUP); !errors.Is(err, syscall.EINTR) {
+		t.Errorf("expected EINTR, got %v", err)
+	}
+	if calls != maxSignalAttempts {
+		t.Errorf("expected %d attempts, got %d", maxSignalAttempts, calls)
+	}
+
+	// Other errors aren't retried.
+	for _, errno := range []syscall.Errno{syscall.ESRCH, syscall.EPERM} {
+		calls = 0
+		kill = func(int32, syscall.Signal) error {
+			calls++
+			return errno
+		}
+		if err := p.SendSignal(syscall.SIGHUP); err != errno {
+			t.Errorf("expected %v, got %v", errno, err)
+		}
+		if calls != 1 {
+			t.Errorf("%v: expected 1 attempt, got %d", errno, calls)
+		}
+	}
+
+	// A context which is done between attempts stops the retries.
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	kill = func(int32, syscall.Signal) error {
+		calls++
+		cancel()
+		return syscall.EINTR
+	}
+	if err := p.SendSignalWithContext(ctx, syscall.SIGHUP); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestSignalAllDedups(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx", cmdline: []string{"nginx", "-c", "/etc/a.conf"}})
+	f.add(fakeProc{pid: 11, name: "nginx", cmdline: []string{"nginx", "-c", "/etc/b.conf"}})
+	f.add(fakeProc{pid: 12, name: "other", cmdline: []string{"other", "-c", "/etc/b.conf"}})
+	rec := recordSignals(t)
+	defer rec.restore()
+	ctx := context.Background()
+
+	// Matching by name and by config overlaps at 11.
+	byName, err := ProcFS{}.processesMatching(ctx, func(p *Process) (bool, error) {
+		return p.Matches(MatchSpec{Name: "nginx"})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	byConfig, err := ProcFS{}.processesMatching(ctx, func(p *Process) (bool, error) {
+		return p.Matches(MatchSpec{CmdlineContains: "b.conf"})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Even a separately-made Process for the same PID is the same target.
+	procs := append(append(byName, byConfig...), &Process{Pid: 10})
+
+	signaled, err := signalAll(ctx, procs, syscall.SIGHUP)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []int32{10, 11, 12}
+	if !reflect.DeepEqual(signaled, exp) {
+		t.Errorf("expected %v signaled, got %v", exp, signaled)
+	}
+	if !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected each of %v to get one signal, got %v", exp, rec.signaled())
+	}
+}
+
+func TestSignalProcsByArg(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server", cmdline: []string{"/bin/server", "--port=80", "--role=master"}})
+	f.add(fakeProc{pid: 11, name: "server", cmdline: []string{"/bin/server", "--port=81", "--role=worker"}})
+	f.add(fakeProc{pid: 12, name: "server", cmdline: []string{"/bin/server", "--role=master"}})
+	f.add(fakeProc{pid: 13, name: "server", cmdline: []string{"/bin/server"}})
+	f.add(fakeProc{pid: 14, name: "kthread"})
+
+	cases := []struct {
+		index int
+		value string
+		exp   []int32
+	}{
+		{2, "--role=master", []int32{10}},
+		{1, "--role=master", []int32{12}},
+		{0, "/bin/server", []int32{10, 11, 12, 13}},
+		{2, "--role=standby", nil},
+		{3, "--role=master", nil},
+		{100, "", nil},
+	}
+	for _, tc := range cases {
+		rec := recordSignals(t)
+		defer rec.restore()
+		n, err := SignalProcsByArg(tc.index, tc.value, Signal(syscall.SIGHUP))
+		if err != nil {
+			t.Errorf("%d=%q: unexpected error: %v", tc.index, tc.value, err)
+			continue
+		}
+		if got := rec.signaled(); n != len(tc.exp) || !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%d=%q: expected %v, got %d: %v", tc.index, tc.value, tc.exp, n, got)
+		}
+	}
+
+	if _, err := SignalProcsByArg(-1, "x", Signal(syscall.SIGHUP)); err == nil {
+		t.Errorf("expected an error for a negative index")
+	}
+}
+
+func TestSignalProcsGlob(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "myapp-web"})
+	f.add(fakeProc{pid: 11, name: "myapp-worker"})
+	f.add(fakeProc{pid: 12, name: "myapp-1"})
+	f.add(fakeProc{pid: 13, name: "myapp-2"})
+	f.add(fakeProc{pid: 14, name: "other"})
+
+	cases := []struct {
+		pattern string
+		exp     []int32
+	}{
+		{"myapp-*", []int32{10, 11, 12, 13}},
+		{"myapp-?", []int32{12, 13}},
+		{"myapp-[0-1]", []int32{12}},
+		{"myapp-[^0-9]*", []int32{10, 11}},
+		{"myapp-web", []int32{10}},
+		{"*-db", nil},
+	}
+	for _, tc := range cases {
+		rec := recordSignals(t)
+		defer rec.restore()
+		n, err := SignalProcsGlob(tc.pattern, Signal(syscall.SIGHUP))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.pattern, err)
+			continue
+		}
+		if got := rec.signaled(); n != len(tc.exp) || !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%q: expected %v, got %d: %v", tc.pattern, tc.exp, n, got)
+		}
+	}
+
+	rec := recordSignals(t)
+	defer rec.restore()
+	for _, pattern := range []string{"myapp-[", "[]a", "\\"} {
+		if _, err := SignalProcsGlob(pattern, Signal(syscall.SIGHUP)); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+	if pids := rec.signaled(); len(pids) != 0 {
+		t.Errorf("expected nothing signaled for bad patterns, got %v", pids)
+	}
+}
+
+func TestSignalProcsInDir(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	dir, remove := tempDir(t)
+	defer remove()
+	base, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("failed to resolve temp dir: %v", err)
+	}
+	worktree := filepath.Join(base, "worktree")
+	for _, dir := range []string{filepath.Join(worktree, "sub"), filepath.Join(base, "other"), filepath.Join(base, "worktree2")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	// Like git-sync's --link.
+	link := filepath.Join(base, "current")
+	if err := os.Symlink("worktree", link); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	cwds := map[int32]string{
+		10: worktree,
+		11: filepath.Join(worktree, "sub"),
+		12: filepath.Join(base, "other"),
+		// Shares a prefix with the worktree, but isn't in it.
+		13: filepath.Join(base, "worktree2"),
+	}
+	for pid, cwd := range cwds {
+		f.add(fakeProc{pid: pid, name: "app"})
+		if err := os.Symlink(cwd, filepath.Join(f.root, strconv.Itoa(int(pid)), "cwd")); err != nil {
+			t.Fatalf("failed to create cwd link: %v", err)
+		}
+	}
+	// No cwd link, as if it were unreadable.
+	f.add(fakeProc{pid: 14, name: "app"})
+
+	if cwd, err := (&Process{Pid: 11}).Cwd(); err != nil || cwd != cwds[11] {
+		t.Errorf("expected cwd %q, got %q: %v", cwds[11], cwd, err)
+	}
+	if _, err := (&Process{Pid: 14}).Cwd(); err == nil {
+		t.Errorf("expected an error for a missing cwd")
+	}
+
+	for _, dir := range []string{link, worktree, worktree + "/"} {
+		rec := recordSignals(t)
+		defer rec.restore()
+		n, err := SignalProcsInDir(dir, Signal(syscall.SIGHUP))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", dir, err)
+		}
+		if exp := []int32{10, 11}; n != len(exp) || !reflect.DeepEqual(rec.signaled(), exp) {
+			t.Errorf("%s: expected %v, got %d: %v", dir, exp, n, rec.signaled())
+		}
+	}
+
+	rec := recordSignals(t)
+	defer rec.restore()
+	for _, dir := range []string{"worktree", filepath.Join(base, "missing")} {
+		if _, err := SignalProcsInDir(dir, Signal(syscall.SIGHUP)); err == nil {
+			t.Errorf("%q: expected an error", dir)
+		}
+	}
+	if pids := rec.signaled(); len(pids) != 0 {
+		t.Errorf("expected nothing signaled for bad directories, got %v", pids)
+	}
+}
+
+func TestSignalProcsBy(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// Started 10s, 100s and 1000s after boot.
+	f.add(fakeProc{pid: 10, name: "worker", start: 1000})
+	f.add(fakeProc{pid: 11, name: "worker", start: 10000})
+	f.add(fakeProc{pid: 12, name: "worker", start: 100000})
+	f.add(fakeProc{pid: 13, name: "other", start: 1000})
+	rec := recordSignals(t)
+	defer rec.restore()
+	clock := useFakeClock()
+	defer clock.restore()
+	clock.Advance(time.Unix(fixtureBootTime+1030, 0).Sub(clock.Now()))
+
+	// Workers which have been up for at least five minutes.
+	n, err := SignalProcsBy(func(p *Process) (bool, error) {
+		name, err := p.Name()
+		if err != nil || name != "worker" {
+			return false, err
+		}
+		age, err := p.Age()
+		return age >= 5*time.Minute, err
+	}, Signal(syscall.SIGHUP))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10, 11}; n != len(exp) || !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v, got %d: %v", exp, n, rec.signaled())
+	}
+
+	// Errors from the predicate skip the process, rather than failing.
+	rec = recordSignals(t)
+	defer rec.restore()
+	n, err = SignalProcsBy(func(p *Process) (bool, error) {
+		if p.Pid == 11 {
+			return true, ErrProcessNotFound
+		}
+		return p.Pid < 13, nil
+	}, Signal(syscall.SIGHUP))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{10, 12}; n != len(exp) || !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v, got %d: %v", exp, n, rec.signaled())
+	}
+}
+
+func TestSignalProcsByWithContext(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	for pid := int32(10); pid <= 13; pid++ {
+		f.add(fakeProc{pid: pid, name: "worker"})
+	}
+	rec := recordSignals(t)
+	defer rec.restore()
+	ctx := context.Background()
+	errUnreadable := errors.New("can't read config")
+	// 11 has exited, and 12's config can't be read.
+	match := func(p *Process) (bool, error) {
+		switch p.Pid {
+		case 11:
+			return false, p.wrapErr("read status", ErrProcessNotFound)
+		case 12:
+			return false, errUnreadable
+		}
+		return true, nil
+	}
+
+	n, err := SignalProcsByWithContext(ctx, match, Signal(syscall.SIGHUP), SkipMatchErrors)
+	if exp := []int32{10, 13}; err != nil || n != len(exp) || !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v, got %d: %v: %v", exp, n, rec.signaled(), err)
+	}
+
+	// An exited process is still skipped, but nothing is signaled once
+	// another error is seen.
+	before := len(rec.signaled())
+	n, err = SignalProcsByWithContext(ctx, match, Signal(syscall.SIGHUP), AbortOnMatchError)
+	if !errors.Is(err, errUnreadable) || n != 0 || len(rec.signaled()) != before {
+		t.Errorf("expected to abort with nothing signaled, got %d: %v", n, err)
+	}
+	var perr *ProcessError
+	if !errors.As(err, &perr) || perr.Pid != 12 {
+		t.Errorf("expected the error to name pid 12, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := SignalProcsByWithContext(cancelled, match, Signal(syscall.SIGHUP), SkipMatchErrors); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := SignalProcsByWithContext(ctx, match, Signal(syscall.SIGHUP), MatchErrorPolicy(5)); err == nil {
+		t.Errorf("expected an error for an invalid policy")
+	}
+}
+
+// BenchmarkSignalProcs signals the two processes with a particular name out
+// of 5000, without actually sending anything.
+func BenchmarkSignalProcs(b *testing.B) {
+	f := newFixture(b)
+	defer f.cleanup()
+	for pid := int32(1); pid <= 5000; pid++ {
+		name := "worker"
+		if pid%2500 == 0 {
+			name = "target"
+		}
+		f.add(fakeProc{pid: pid, name: name})
+	}
+	orig := sendSignal
+	sendSignal = func(*Process, context.Context, syscall.Signal) error { return nil }
+	defer func() { sendSignal = orig }()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n, err := SignalProcs("target", Signal(syscall.SIGHUP)); err != nil || n != 2 {
+			b.Fatalf("unexpected result: %d, %v", n, err)
+		}
+	}
+}
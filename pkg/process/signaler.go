@@ -0,0 +1,229 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Signaler signals every process with a given name, like SignalProcs, but
+// can be told to not do so too often, to protect the target from reloading
+// continuously if syncs are very frequent.  It is safe for concurrent use.
+type Signaler struct {
+	// Name is the name of the processes to signal.
+	Name string
+	// Signal is the signal to send.
+	Signal Signal
+	// MinInterval is the minimum time between signals.  Requests to signal
+	// which come in sooner than this after the last successful one are
+	// dropped, and counted by Suppressed.  Zero means no limit.
+	MinInterval time.Duration
+
+	// Clock, if set, is used instead of the real clock to time
+	// MinInterval.
+	Clock Clock
+
+	mutex      sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// Send signals the processes, unless this would be too soon after the last
+// time.  It returns the number of processes signaled and whether a signal was
+// sent at all.
+func (s *Signaler) Send() (int, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := clockOr(s.Clock).Now()
+	if s.MinInterval > 0 && !s.last.IsZero() && now.Sub(s.last) < s.MinInterval {
+		s.suppressed++
+		return 0, false, nil
+	}
+
+	n, err := SignalProcs(s.Name, s.Signal)
+	if err == nil {
+		s.last = now
+	}
+	return n, true, err
+}
+
+// Suppressed returns how many requests to signal have been dropped for
+// coming too soon.
+func (s *Signaler) Suppressed() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.suppressed
+}
+
+// ChangeSignaler signals every process with a given name, like SignalProcs,
+// but only when the synced content has changed since the last signal, so
+// that the target isn't reloaded on every poll.  It is safe for concurrent
+// use.
+type ChangeSignaler struct {
+	// Name is the name of the processes to signal.
+	Name string
+	// Signal is the signal to send.
+	Signal Signal
+
+	mutex   sync.Mutex
+	lastSHA string
+}
+
+// SendIfChanged signals the processes, unless sha, the commit which was just
+// synced, is the one they were last signaled for.  It returns the number of
+// processes signaled and whether a signal was sent at all.  sha is only
+// remembered if signaling succeeds, so that a failure is retried on the next
+// sync even if nothing has changed by then.
+func (s *ChangeSignaler) SendIfChanged(sha string) (int, bool, error) {
+	if sha == "" {
+		return 0, false, errors.New("no commit SHA given")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sha == s.lastSHA {
+		return 0, false, nil
+	}
+
+	n, err := SignalProcs(s.Name, s.Signal)
+	if err == nil {
+		s.lastSHA = sha
+	}
+	return n, true, err
+}
+
+// Seed records sha as the commit the processes were last signaled for,
+// without signaling them, as for a checkout which they were already serving
+// before the signaler was created.
+func (s *ChangeSignaler) Seed(sha string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastSHA = sha
+}
+
+// LastSHA returns the commit SHA which the processes were last signaled for,
+// or "" if they haven't been.
+func (s *ChangeSignaler) LastSHA() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastSHA
+}
+
+// PeriodicSignaler signals every process with a given name on a schedule,
+// for services which want, say, a SIGUSR1 every so often to rotate their
+// logs.  It is safe for concurrent use.
+type PeriodicSignaler struct {
+	// Name is the name of the processes to signal.
+	Name string
+	// Signal is the signal to send.
+	Signal Signal
+	// Interval is the time between signals.
+	Interval time.Duration
+	// Jitter, if set, adds a random delay of up to this much to each
+	// interval, so that many signalers started together drift apart.
+	Jitter time.Duration
+	// Clock, if set, is used instead of the real clock to wait between
+	// signals.
+	Clock Clock
+	// OnError, if set, is called, from the signaler's goroutine, with each
+	// error from signaling.  Failures are also counted by Failures.
+	OnError func(error)
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	// done is closed when the background goroutine has exited.
+	done     chan struct{}
+	failures int
+}
+
+// Start begins signaling in a background goroutine, which runs until ctx is
+// done or Stop is called.  The first signal is sent after one interval.
+// Having no process to signal, because the target hasn't started yet or has
+// exited, is not an error.  Other failures are reported to OnError, and the
+// signaler tries again next time either way.  A PeriodicSignaler may only be
+// started once.
+func (s *PeriodicSignaler) Start(ctx context.Context) error {
+	if err := s.Signal.valid(); err != nil {
+		return err
+	}
+	if s.Interval <= 0 {
+		return fmt.Errorf("invalid interval %v", s.Interval)
+	}
+	if s.Jitter < 0 {
+		return fmt.Errorf("invalid jitter %v", s.Jitter)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done != nil {
+		return errors.New("periodic signaler already started")
+	}
+	c := clockOr(s.Clock)
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		for {
+			wait := s.Interval
+			if s.Jitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(s.Jitter)))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.After(wait):
+			}
+			// Failures are transient as far as we can tell, so try again
+			// next time.
+			if _, err := SignalProcs(s.Name, s.Signal); err != nil {
+				s.mutex.Lock()
+				s.failures++
+				s.mutex.Unlock()
+				if s.OnError != nil {
+					s.OnError(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Failures returns how many times signaling has failed.
+func (s *PeriodicSignaler) Failures() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.failures
+}
+
+// Stop halts signaling, and returns once the background goroutine has
+// exited.  It is safe to call Stop more than once, including concurrently,
+// and on a signaler which was never started.
+func (s *PeriodicSignaler) Stop() {
+	s.mutex.Lock()
+	cancel, done := s.cancel, s.done
+	s.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalerMinInterval(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx"})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	clock := newFakeClock()
+	s := &Signaler{
+		Name:        "nginx",
+		Signal:      Signal(syscall.SIGHUP),
+		MinInterval: time.Minute,
+		Clock:       clock,
+	}
+	send := func(expSent bool) {
+		t.Helper()
+		n, sent, err := s.Send()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sent != expSent {
+			t.Fatalf("at %v: expected sent=%v, got %v", clock.Now(), expSent, sent)
+		}
+		if sent && n != 1 {
+			t.Fatalf("expected 1 process signaled, got %d", n)
+		}
+	}
+
+	send(true)
+	clock.Advance(10 * time.Second)
+	send(false)
+	clock.Advance(49 * time.Second)
+	send(false)
+	clock.Advance(time.Second)
+	send(true)
+	clock.Advance(time.Second)
+	send(false)
+
+	if n := s.Suppressed(); n != 3 {
+		t.Errorf("expected 3 suppressed, got %d", n)
+	}
+	if n := len(rec.signaled()); n != 2 {
+		t.Errorf("expected 2 signals sent, got %d", n)
+	}
+}
+
+func TestSignalerNoLimit(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx"})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	s := &Signaler{Name: "nginx", Signal: Signal(syscall.SIGHUP)}
+	for i := 0; i < 3; i++ {
+		if _, sent, err := s.Send(); err != nil || !sent {
+			t.Fatalf("expected a signal to be sent, got %v: %v", sent, err)
+		}
+	}
+	if n := s.Suppressed(); n != 0 {
+		t.Errorf("expected none suppressed, got %d", n)
+	}
+	if n := len(rec.signaled()); n != 3 {
+		t.Errorf("expected 3 signals sent, got %d", n)
+	}
+}
+
+func TestChangeSignaler(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx"})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	s := &ChangeSignaler{Name: "nginx", Signal: Signal(syscall.SIGHUP)}
+	if sha := s.LastSHA(); sha != "" {
+		t.Errorf("expected no SHA before signaling, got %q", sha)
+	}
+	send := func(sha string, expSent bool) {
+		t.Helper()
+		n, sent, err := s.SendIfChanged(sha)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", sha, err)
+		}
+		if sent != expSent {
+			t.Fatalf("%s: expected sent=%v, got %v", sha, expSent, sent)
+		}
+		if sent && n != 1 {
+			t.Fatalf("%s: expected 1 process signaled, got %d", sha, n)
+		}
+		if last := s.LastSHA(); last != sha {
+			t.Fatalf("expected last SHA %q, got %q", sha, last)
+		}
+	}
+
+	send("aaaa", true)
+	send("aaaa", false)
+	send("bbbb", true)
+	send("bbbb", false)
+	send("aaaa", true)
+	if n := len(rec.signaled()); n != 3 {
+		t.Errorf("expected 3 signals sent, got %d", n)
+	}
+
+	if _, _, err := s.SendIfChanged(""); err == nil {
+		t.Errorf("expected an error for an empty SHA")
+	}
+}
+
+func TestChangeSignalerSeed(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx"})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	s := &ChangeSignaler{Name: "nginx", Signal: Signal(syscall.SIGHUP)}
+	s.Seed("aaaa")
+	if sha := s.LastSHA(); sha != "aaaa" {
+		t.Errorf("expected the seeded SHA, got %q", sha)
+	}
+	if _, sent, err := s.SendIfChanged("aaaa"); err != nil || sent {
+		t.Fatalf("expected the seeded SHA not to be signaled, got sent=%v: %v", sent, err)
+	}
+	if _, sent, err := s.SendIfChanged("bbbb"); err != nil || !sent {
+		t.Fatalf("expected a new SHA to be signaled, got sent=%v: %v", sent, err)
+	}
+	if exp := []int32{10}; !reflect.DeepEqual(rec.signaled(), exp) {
+		t.Errorf("expected %v signaled, got %v", exp, rec.signaled())
+	}
+}
+
+func TestChangeSignalerRetriesFailure(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx"})
+	rec := recordSignals(t)
+	defer rec.restore()
+	rec.errs[10] = syscall.EPERM
+
+	s := &ChangeSignaler{Name: "nginx", Signal: Signal(syscall.SIGHUP)}
+	if _, sent, err := s.SendIfChanged("aaaa"); err == nil || !sent {
+		t.Fatalf("expected a failed signal, got sent=%v: %v", sent, err)
+	}
+	if sha := s.LastSHA(); sha != "" {
+		t.Errorf("expected a failed signal not to be remembered, got %q", sha)
+	}
+
+	delete(rec.errs, 10)
+	if n, sent, err := s.SendIfChanged("aaaa"); err != nil || !sent || n != 1 {
+		t.Fatalf("expected the same SHA to be signaled again, got %d, %v: %v", n, sent, err)
+	}
+	if sha := s.LastSHA(); sha != "aaaa" {
+		t.Errorf("expected last SHA %q, got %q", "aaaa", sha)
+	}
+}
+
+func TestPeriodicSignaler(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx"})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	clock := newFakeClock()
+	errs := make(chan error, 10)
+	s := &PeriodicSignaler{
+		Name:     "nginx",
+		Signal:   Signal(syscall.SIGUSR1),
+		Interval: time.Minute,
+		Jitter:   10 * time.Second,
+		Clock:    clock,
+		OnError:  func(err error) { errs <- err },
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Stop()
+	if err := s.Start(context.Background()); err == nil {
+		t.Errorf("expected an error starting twice")
+	}
+
+	// Each wait is between Interval and Interval+Jitter, so the clock must
+	// be moved on by at least that for every signal.
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	if n := len(rec.signaled()); n != 0 {
+		t.Fatalf("expected no signals within the interval, got %d", n)
+	}
+	clock.Advance(11 * time.Second)
+	for i := 0; i < 4; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(70 * time.Second)
+	}
+	clock.BlockUntil(1)
+	if n := len(rec.signaled()); n != 5 {
+		t.Errorf("expected 5 signals, got %d", n)
+	}
+
+	// The target exiting doesn't stop the signaler.
+	if err := os.RemoveAll(filepath.Join(f.root, "10")); err != nil {
+		t.Fatalf("failed to remove pid 10: %v", err)
+	}
+	clock.Advance(70 * time.Second)
+	clock.BlockUntil(1)
+	f.add(fakeProc{pid: 11, name: "nginx"})
+	clock.Advance(70 * time.Second)
+	clock.BlockUntil(1)
+	if pids := rec.signaled(); len(pids) != 6 || pids[5] != 11 {
+		t.Errorf("expected the restarted process to be signaled, got %v", pids)
+	}
+	if n := s.Failures(); n != 0 {
+		t.Errorf("expected no failures, got %d", n)
+	}
+
+	// Failures are reported, and counted, and signaling carries on.
+	rec.mu.Lock()
+	rec.errs[11] = syscall.EPERM
+	rec.mu.Unlock()
+	clock.Advance(70 * time.Second)
+	clock.BlockUntil(1)
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	default:
+		t.Errorf("expected the failure to be reported")
+	}
+	if n := s.Failures(); n != 1 {
+		t.Errorf("expected 1 failure, got %d", n)
+	}
+	rec.mu.Lock()
+	delete(rec.errs, 11)
+	rec.mu.Unlock()
+	clock.Advance(70 * time.Second)
+	clock.BlockUntil(1)
+	if pids := rec.signaled(); len(pids) != 7 {
+		t.Errorf("expected signaling to carry on after a failure, got %v", pids)
+	}
+
+	s.Stop()
+	s.Stop()
+	clock.Advance(time.Hour)
+	if n := len(rec.signaled()); n != 7 {
+		t.Errorf("expected no signals after Stop, got %d", n)
+	}
+}
+
+func TestPeriodicSignalerInvalid(t *testing.T) {
+	for _, s := range []*PeriodicSignaler{
+		{Name: "nginx", Signal: Signal(0), Interval: time.Minute},
+		{Name: "nginx", Signal: Signal(syscall.SIGUSR1)},
+		{Name: "nginx", Signal: Signal(syscall.SIGUSR1), Interval: time.Minute, Jitter: -time.Second},
+	} {
+		if err := s.Start(context.Background()); err == nil {
+			t.Errorf("%+v: expected an error", s)
+			s.Stop()
+		}
+	}
+	// Stopping a signaler which never started is harmless.
+	(&PeriodicSignaler{}).Stop()
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrSmapsRollupUnsupported is returned by MemoryMapsRollup, wrapped in a
+// ProcessError, when the process exists but the kernel, older than Linux
+// 4.14, doesn't provide /proc/<pid>/smaps_rollup.
+var ErrSmapsRollupUnsupported = errors.New("smaps_rollup is not supported by this kernel")
+
+// MemoryMapsRollupStat sums the memory use of all of a process's mappings,
+// from /proc/<pid>/smaps_rollup.  All sizes are in bytes.
+type MemoryMapsRollupStat struct {
+	// Rss is the resident memory, counting shared pages in full.
+	Rss uint64
+	// Pss is the resident memory, counting each shared page divided by the
+	// number of processes sharing it.  This is the fairest measure of what
+	// a process costs.
+	Pss          uint64
+	SharedClean  uint64
+	SharedDirty  uint64
+	PrivateClean uint64
+	PrivateDirty uint64
+	Referenced   uint64
+	Anonymous    uint64
+	Swap         uint64
+	SwapPss      uint64
+	Locked       uint64
+}
+
+// MemoryMapsRollup returns the process's memory use summed over all of its
+// mappings.  This is more accurate than statm, since it distinguishes shared
+// from private memory.
+func (p *Process) MemoryMapsRollup() (*MemoryMapsRollupStat, error) {
+	data, err := p.readFile("smaps_rollup")
+	if errors.Is(err, ErrProcessNotFound) {
+		if _, err := p.fs.NewProcess(p.Pid); err == nil {
+			return nil, p.wrapErr("read smaps_rollup", ErrSmapsRollupUnsupported)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stat := &MemoryMapsRollupStat{}
+	for key, val := range parseStatus(data) {
+		if err := stat.set(key, val); err != nil {
+			return nil, p.errorf("read smaps_rollup", "malformed %s %q: %v", key, val, err)
+		}
+	}
+	return stat, nil
+}
+
+// set sets the field of s for the smaps key, from a value like "16 kB".
+// Keys which s has no field for are ignored, since not every kernel reports
+// the same ones.
+func (s *MemoryMapsRollupStat) set(key, val string) error {
+	var dest *uint64
+	switch key {
+	case "Rss":
+		dest = &s.Rss
+	case "Pss":
+		dest = &s.Pss
+	case "Shared_Clean":
+		dest = &s.SharedClean
+	case "Shared_Dirty":
+		dest = &s.SharedDirty
+	case "Private_Clean":
+		dest = &s.PrivateClean
+	case "Private_Dirty":
+		dest = &s.PrivateDirty
+	case "Referenced":
+		dest = &s.Referenced
+	case "Anonymous":
+		dest = &s.Anonymous
+	case "Swap":
+		dest = &s.Swap
+	case "SwapPss":
+		dest = &s.SwapPss
+	case "Locked":
+		dest = &s.Locked
+	default:
+		return nil
+	}
+	kb, err := strconv.ParseUint(strings.TrimSuffix(val, " kB"), 10, 64)
+	if err != nil {
+		return err
+	}
+	*dest = kb * 1024
+	return nil
+}
+
+// MaxMemoryMaps is the default limit on how many mappings MemoryMaps reads,
+// which matches the kernel's default vm.max_map_count.
+const MaxMemoryMaps = 65530
+
+// MemoryMap is one of a process's memory mappings, from /proc/<pid>/smaps.
+// Sizes are in bytes.
+type MemoryMap struct {
+	// Start and End are the address range, End being exclusive.
+	Start, End uint64
+	// Perms is e.g. "r-xp".
+	Perms  string
+	Offset uint64
+	// Dev is the "major:minor" device of the mapped file.
+	Dev   string
+	Inode uint64
+	// Path is the mapped file, a pseudo-path like "[heap]", or empty for
+	// an anonymous mapping.
+	Path string
+	MemoryMapsRollupStat
+}
+
+// MemoryMaps returns the process's memory mappings, in address order, with
+// the memory use of each.  This is much more work for the kernel and for us
+// than MemoryMapsRollup, so is best kept for investigating where memory
+// goes.  At most limit mappings are read, or MaxMemoryMaps if limit is not
+// positive, and the second result reports whether there were more.
+func (p *Process) MemoryMaps(limit int) ([]MemoryMap, bool, error) {
+	if limit <= 0 {
+		limit = MaxMemoryMaps
+	}
+	file, err := p.openFile("smaps")
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	maps, truncated, err := parseSmaps(file, limit)
+	if err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			// The process exited while we were reading.
+			err = ErrProcessNotFound
+		}
+		return nil, false, p.wrapErr("read smaps", err)
+	}
+	return maps, truncated, nil
+}
+
+// parseSmaps parses up to limit mappings from an smaps file.
+func parseSmaps(r io.Reader, limit int) ([]MemoryMap, bool, error) {
+	var maps []MemoryMap
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasSuffix(fields[0], ":") {
+			if len(maps) == 0 {
+				return nil, false, fmt.Errorf("%q before the first mapping", line)
+			}
+			key := strings.TrimSuffix(fields[0], ":")
+			val := strings.Join(fields[1:], " ")
+			if err := maps[len(maps)-1].set(key, val); err != nil {
+				return nil, false, fmt.Errorf("malformed %s %q: %v", key, val, err)
+			}
+			continue
+		}
+		if len(maps) >= limit {
+			return maps, true, nil
+		}
+		m, err := parseMapping(line)
+		if err != nil {
+			return nil, false, err
+		}
+		maps = append(maps, m)
+	}
+	return maps, false, scanner.Err()
+}
+
+// parseMapping parses the header line of a mapping in smaps, like
+// "7f1c2a000000-7f1c2a021000 r-xp 00000000 08:01 1234  /usr/lib/libc.so.6".
+func parseMapping(line string) (MemoryMap, error) {
+	var m MemoryMap
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return m, fmt.Errorf("malformed mapping %q", line)
+	}
+	addrs := strings.SplitN(fields[0], "-", 2)
+	if len(addrs) != 2 {
+		return m, fmt.Errorf("malformed address range in mapping %q", line)
+	}
+	var err error
+	if m.Start, err = strconv.ParseUint(addrs[0], 16, 64); err != nil {
+		return m, fmt.Errorf("malformed start address in mapping %q: %v", line, err)
+	}
+	if m.End, err = strconv.ParseUint(addrs[1], 16, 64); err != nil {
+		return m, fmt.Errorf("malformed end address in mapping %q: %v", line, err)
+	}
+	if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+		return m, fmt.Errorf("malformed offset in mapping %q: %v", line, err)
+	}
+	if m.Inode, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+		return m, fmt.Errorf("malformed inode in mapping %q: %v", line, err)
+	}
+	m.Perms = fields[1]
+	m.Dev = fields[3]
+	// The path may itself contain spaces, so is whatever follows the inode.
+	rest := line
+	for i := 0; i < 5 && rest != ""; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		if j := strings.IndexByte(rest, ' '); j >= 0 {
+			rest = rest[j:]
+		} else {
+			rest = ""
+		}
+	}
+	m.Path = strings.TrimSpace(rest)
+	return m, nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const fixtureSmapsRollup = `55d5c1a00000-7ffc2f5f1000 ---p 00000000 00:00 0                          [rollup]
+Rss:                5120 kB
+Pss:                2048 kB
+Pss_Anon:           1024 kB
+Pss_File:           1024 kB
+Pss_Shmem:             0 kB
+Shared_Clean:       3072 kB
+Shared_Dirty:          0 kB
+Private_Clean:      1024 kB
+Private_Dirty:      1024 kB
+Referenced:         5120 kB
+Anonymous:          1024 kB
+LazyFree:              0 kB
+AnonHugePages:         0 kB
+ShmemPmdMapped:        0 kB
+FilePmdMapped:         0 kB
+Shared_Hugetlb:        0 kB
+Private_Hugetlb:       0 kB
+Swap:                 16 kB
+SwapPss:               8 kB
+Locked:                0 kB
+`
+
+func TestMemoryMapsRollup(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.add(fakeProc{pid: 43, name: "old-kernel"})
+	f.write("42/smaps_rollup", fixtureSmapsRollup)
+
+	stat, err := (&Process{Pid: 42}).MemoryMapsRollup()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := &MemoryMapsRollupStat{
+		Rss:          5120 * 1024,
+		Pss:          2048 * 1024,
+		SharedClean:  3072 * 1024,
+		PrivateClean: 1024 * 1024,
+		PrivateDirty: 1024 * 1024,
+		Referenced:   5120 * 1024,
+		Anonymous:    1024 * 1024,
+		Swap:         16 * 1024,
+		SwapPss:      8 * 1024,
+	}
+	if !reflect.DeepEqual(stat, exp) {
+		t.Errorf("expected %+v, got %+v", exp, stat)
+	}
+
+	if _, err := (&Process{Pid: 43}).MemoryMapsRollup(); !errors.Is(err, ErrSmapsRollupUnsupported) {
+		t.Errorf("expected ErrSmapsRollupUnsupported, got %v", err)
+	}
+	if _, err := (&Process{Pid: 44}).MemoryMapsRollup(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+
+	f.write("42/smaps_rollup", "Rss:  lots kB\n")
+	if _, err := (&Process{Pid: 42}).MemoryMapsRollup(); err == nil {
+		t.Errorf("expected an error for a malformed size")
+	}
+}
+
+const fixtureSmaps = `55d5c1a00000-55d5c1a21000 r-xp 00001000 08:01 1234                       /usr/bin/my app
+Size:                132 kB
+KernelPageSize:        4 kB
+MMUPageSize:           4 kB
+Rss:                 128 kB
+Pss:                  64 kB
+Shared_Clean:        128 kB
+Shared_Dirty:          0 kB
+Private_Clean:         0 kB
+Private_Dirty:         0 kB
+Referenced:          128 kB
+Anonymous:             0 kB
+Swap:                  0 kB
+SwapPss:               0 kB
+Locked:                0 kB
+THPeligible:    0
+VmFlags: rd ex mr mw me dw
+7ffc2f5d0000-7ffc2f5f1000 rw-p 00000000 00:00 0                          [stack]
+Size:                132 kB
+Rss:                  12 kB
+Pss:                  12 kB
+Private_Dirty:        12 kB
+Referenced:           12 kB
+Anonymous:            12 kB
+Swap:                  4 kB
+SwapPss:               4 kB
+VmFlags: rd wr mr mw me gd ac
+`
+
+func TestMemoryMaps(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "my app"})
+	f.write("42/smaps", fixtureSmaps)
+	p := &Process{Pid: 42}
+
+	maps, truncated, err := p.MemoryMaps(MaxMemoryMaps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if truncated {
+		t.Errorf("expected all mappings to be read")
+	}
+	exp := []MemoryMap{{
+		Start:  0x55d5c1a00000,
+		End:    0x55d5c1a21000,
+		Perms:  "r-xp",
+		Offset: 0x1000,
+		Dev:    "08:01",
+		Inode:  1234,
+		Path:   "/usr/bin/my app",
+		MemoryMapsRollupStat: MemoryMapsRollupStat{
+			Rss:         128 * 1024,
+			Pss:         64 * 1024,
+			SharedClean: 128 * 1024,
+			Referenced:  128 * 1024,
+		},
+	}, {
+		Start: 0x7ffc2f5d0000,
+		End:   0x7ffc2f5f1000,
+		Perms: "rw-p",
+		Dev:   "00:00",
+		Path:  "[stack]",
+		MemoryMapsRollupStat: MemoryMapsRollupStat{
+			Rss:          12 * 1024,
+			Pss:          12 * 1024,
+			PrivateDirty: 12 * 1024,
+			Referenced:   12 * 1024,
+			Anonymous:    12 * 1024,
+			Swap:         4 * 1024,
+			SwapPss:      4 * 1024,
+		},
+	}}
+	if !reflect.DeepEqual(maps, exp) {
+		t.Errorf("expected %+v, got %+v", exp, maps)
+	}
+
+	maps, truncated, err = p.MemoryMaps(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !truncated || !reflect.DeepEqual(maps, exp[:1]) {
+		t.Errorf("expected only the first mapping and truncation, got %+v, %v", maps, truncated)
+	}
+	for _, limit := range []int{0, -1} {
+		maps, truncated, err = p.MemoryMaps(limit)
+		if err != nil || truncated || !reflect.DeepEqual(maps, exp) {
+			t.Errorf("limit %d: expected every mapping, got %+v, %v: %v", limit, maps, truncated, err)
+		}
+	}
+
+	if _, _, err := (&Process{Pid: 44}).MemoryMaps(MaxMemoryMaps); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+
+	// An anonymous mapping has no path.
+	f.write("42/smaps", "7f0000000000-7f0000001000 rw-p 00000000 00:00 0 \nRss: 4 kB\n")
+	if maps, _, err := p.MemoryMaps(MaxMemoryMaps); err != nil || len(maps) != 1 || maps[0].Path != "" || maps[0].Rss != 4096 {
+		t.Errorf("unexpected anonymous mapping %+v: %v", maps, err)
+	}
+
+	for _, bad := range []string{
+		"Rss: 4 kB\n",
+		"7f0000000000 rw-p 00000000 00:00 0\n",
+		"7f0000000000-7f0000001000 rw-p 00000000 00:00\n",
+		"7f0000000000-7f0000001000 rw-p 00000000 00:00 0\nRss: lots kB\n",
+	} {
+		f.write("42/smaps", bad)
+		if _, _, err := p.MemoryMaps(MaxMemoryMaps); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SeccompMode is the seccomp mode of a process.
+type SeccompMode int
+
+const (
+	// SeccompUnknown means the kernel does not report the seccomp mode.
+	SeccompUnknown SeccompMode = -1
+	// SeccompDisabled means the process is not confined by seccomp.
+	SeccompDisabled SeccompMode = 0
+	// SeccompStrict means the process may only use read, write, _exit and
+	// sigreturn.
+	SeccompStrict SeccompMode = 1
+	// SeccompFilter means the process is confined by a BPF filter.
+	SeccompFilter SeccompMode = 2
+)
+
+func (m SeccompMode) String() string {
+	switch m {
+	case SeccompUnknown:
+		return "unknown"
+	case SeccompDisabled:
+		return "disabled"
+	case SeccompStrict:
+		return "strict"
+	case SeccompFilter:
+		return "filter"
+	}
+	return fmt.Sprintf("SeccompMode(%d)", int(m))
+}
+
+// Seccomp returns the seccomp mode of the process.  Kernels older than 3.8
+// don't report it, in which case this returns SeccompUnknown and no error.
+func (p *Process) Seccomp() (SeccompMode, error) {
+	fields, err := p.StatusMap()
+	if err != nil {
+		return SeccompUnknown, err
+	}
+	val, ok := fields["Seccomp"]
+	if !ok {
+		return SeccompUnknown, nil
+	}
+	switch val {
+	case "0":
+		return SeccompDisabled, nil
+	case "1":
+		return SeccompStrict, nil
+	case "2":
+		return SeccompFilter, nil
+	}
+	return SeccompUnknown, p.errorf("read status", "unknown Seccomp mode %q", val)
+}
+
+// SignalMask is a set of signals, as reported by the Sig* fields of
+// /proc/<pid>/status.
+type SignalMask uint64
+
+// Has returns true if sig is in the set.
+func (m SignalMask) Has(sig Signal) bool {
+	if sig < 1 || sig > 64 {
+		return false
+	}
+	return m&(1<<uint(sig-1)) != 0
+}
+
+// Names returns the names of the signals in the set, e.g. "SIGHUP", in
+// signal number order.  Signals without a name, such as the real-time ones,
+// are named by number, as by Signal's String method.
+func (m SignalMask) Names() []string {
+	var names []string
+	for sig := Signal(1); sig <= 64; sig++ {
+		if m.Has(sig) {
+			names = append(names, sig.String())
+		}
+	}
+	return names
+}
+
+// parseSignalMask parses a mask like "0000000000004a02", in which bit n-1
+// stands for signal n.
+func parseSignalMask(s string) (SignalMask, error) {
+	m, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed signal mask %q: %v", s, err)
+	}
+	return SignalMask(m), nil
+}
+
+// signalMask returns one of the signal mask fields of the process's status,
+// e.g. "SigCgt" for the signals it has handlers for.
+func (p *Process) signalMask(field string) (SignalMask, error) {
+	val, err := p.statusField(field)
+	if err != nil {
+		return 0, err
+	}
+	return parseSignalMask(val)
+}
+
+// SignalsStat holds the signal masks of a process.
+type SignalsStat struct {
+	// Pending are the signals sent to the thread which reads them, the
+	// main thread for Signals, which have yet to be delivered.
+	Pending SignalMask
+	// Blocked are the signals which are held pending until unblocked.
+	Blocked SignalMask
+	// Ignored are the signals which are discarded on delivery.
+	Ignored SignalMask
+	// Caught are the signals which the process has handlers for.
+	Caught SignalMask
+}
+
+// Signals returns the signal masks of the process, from the SigPnd, SigBlk,
+// SigIgn and SigCgt fields of its status, for finding out why a signal had
+// no effect.
+func (p *Process) Signals() (*SignalsStat, error) {
+	fields, err := p.StatusMap()
+	if err != nil {
+		return nil, err
+	}
+	stat := &SignalsStat{}
+	for _, m := range []struct {
+		field string
+		dest  *SignalMask
+	}{
+		{"SigPnd", &stat.Pending},
+		{"SigBlk", &stat.Blocked},
+		{"SigIgn", &stat.Ignored},
+		{"SigCgt", &stat.Caught},
+	} {
+		val, ok := fields[m.field]
+		if !ok {
+			return nil, p.errorf("read status", "no %s field", m.field)
+		}
+		if *m.dest, err = parseSignalMask(val); err != nil {
+			return nil, p.wrapErr("read status", err)
+		}
+	}
+	return stat, nil
+}
+
+// RssBytes returns the resident set size of the process, from VmRSS.
+func (p *Process) RssBytes() (uint64, error) {
+	return p.statusBytes("VmRSS")
+}
+
+// SwapBytes returns how much of the process's memory is swapped out, from
+// VmSwap.
+func (p *Process) SwapBytes() (uint64, error) {
+	return p.statusBytes("VmSwap")
+}
+
+// VmSize returns the total virtual memory size of the process, from VmSize.
+func (p *Process) VmSize() (uint64, error) {
+	return p.statusBytes("VmSize")
+}
+
+// VmData returns the size of the process's data segment, from VmData.
+func (p *Process) VmData() (uint64, error) {
+	return p.statusBytes("VmData")
+}
+
+// StackBytes returns the size of the main thread's stack, from VmStk, which
+// grows with deep recursion.
+func (p *Process) StackBytes() (uint64, error) {
+	return p.statusBytes("VmStk")
+}
+
+// statusBytes returns a status field given in kB, e.g. "VmSwap:\t  12 kB",
+// converted to bytes.  Kernel threads have no memory fields, so for them
+// this is an error.
+func (p *Process) statusBytes(field string) (uint64, error) {
+	val, err := p.statusField(field)
+	if err != nil {
+		return 0, err
+	}
+	kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(val, "kB")), 10, 64)
+	if err != nil {
+		return 0, p.errorf("read status", "malformed %s %q: %v", field, val, err)
+	}
+	return kb * 1024, nil
+}
+
+// Uids returns the real, effective and saved user IDs of the process, from
+// the Uid field of its status.
+func (p *Process) Uids() (real, effective, saved uint32, err error) {
+	val, err := p.statusField("Uid")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	fields := strings.Fields(val)
+	if len(fields) < 3 {
+		return 0, 0, 0, p.errorf("read status", "malformed Uid %q", val)
+	}
+	var ids [3]uint32
+	for i := range ids {
+		id, err := strconv.ParseUint(fields[i], 10, 32)
+		if err != nil {
+			return 0, 0, 0, p.errorf("read status", "malformed Uid %q: %v", val, err)
+		}
+		ids[i] = uint32(id)
+	}
+	return ids[0], ids[1], ids[2], nil
+}
+
+// Umask returns the file mode creation mask of the process, e.g. 0022, from
+// the Umask field of its status.  Kernels older than 4.7 don't report it, in
+// which case an error is returned.
+func (p *Process) Umask() (os.FileMode, error) {
+	val, err := p.statusField("Umask")
+	if err != nil {
+		return 0, err
+	}
+	mask, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mask&^0777 != 0 {
+		return 0, p.errorf("read status", "malformed Umask %q", val)
+	}
+	return os.FileMode(mask), nil
+}
+
+// CoreDumping returns true if the process is in the middle of dumping core,
+// which is why a crashed process can linger after a fatal signal.  Kernels
+// older than 4.15 don't report it, in which case this returns false and no
+// error.
+func (p *Process) CoreDumping() (bool, error) {
+	fields, err := p.StatusMap()
+	if err != nil {
+		return false, err
+	}
+	val, ok := fields["CoreDumping"]
+	if !ok {
+		return false, nil
+	}
+	switch val {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, p.errorf("read status", "malformed CoreDumping %q", val)
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestSeccomp(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("1/status", "Name:\tinit\nSeccomp:\t0\n")
+	f.write("2/status", "Name:\tchrome\nSeccomp:\t2\nSeccomp_filters:\t1\n")
+	f.write("3/status", "Name:\tsandbox\nSeccomp:\t1\n")
+	// Older kernels have no Seccomp field.
+	f.write("4/status", "Name:\told\nState:\tS (sleeping)\n")
+
+	cases := []struct {
+		pid  int32
+		mode SeccompMode
+	}{
+		{1, SeccompDisabled},
+		{2, SeccompFilter},
+		{3, SeccompStrict},
+		{4, SeccompUnknown},
+	}
+	for _, tc := range cases {
+		mode, err := (&Process{Pid: tc.pid}).Seccomp()
+		if err != nil {
+			t.Errorf("pid %d: unexpected error: %v", tc.pid, err)
+			continue
+		}
+		if mode != tc.mode {
+			t.Errorf("pid %d: expected %v, got %v", tc.pid, tc.mode, mode)
+		}
+	}
+
+	f.write("5/status", "Name:\tfuture\nSeccomp:\t3\n")
+	if _, err := (&Process{Pid: 5}).Seccomp(); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+	if _, err := (&Process{Pid: 6}).Seccomp(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestSignalMask(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// nginx catches HUP, INT, QUIT, USR1, USR2, ALRM, TERM, CHLD, WINCH, IO.
+	f.write("42/status", "Name:\tnginx\nSigQ:\t0/31366\nSigPnd:\t0000000000000000\n"+
+		"SigBlk:\t0000000000000000\nSigIgn:\t0000000000001000\nSigCgt:\t0000000018016a07\n")
+
+	caught, err := (&Process{Pid: 42}).signalMask("SigCgt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGUSR1,
+		syscall.SIGUSR2, syscall.SIGALRM, syscall.SIGTERM, syscall.SIGCHLD, syscall.SIGWINCH, syscall.SIGIO} {
+		if !caught.Has(Signal(sig)) {
+			t.Errorf("expected %v to be caught", Signal(sig))
+		}
+	}
+	for _, sig := range []syscall.Signal{syscall.SIGKILL, syscall.SIGPIPE, syscall.SIGSEGV} {
+		if caught.Has(Signal(sig)) {
+			t.Errorf("expected %v to not be caught", Signal(sig))
+		}
+	}
+
+	ignored, err := (&Process{Pid: 42}).signalMask("SigIgn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ignored != 1<<(syscall.SIGPIPE-1) {
+		t.Errorf("expected only SIGPIPE to be ignored, got %x", uint64(ignored))
+	}
+
+	if SignalMask(^uint64(0)).Has(0) || SignalMask(^uint64(0)).Has(65) {
+		t.Errorf("expected out of range signals to never be in a mask")
+	}
+	if _, err := parseSignalMask("zz"); err == nil {
+		t.Errorf("expected an error for a malformed mask")
+	}
+}
+
+func TestSignals(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// A process with a SIGTERM pending which it blocks, that ignores SIGPIPE,
+	// and catches HUP, INT, QUIT, USR1, USR2, ALRM, TERM, CHLD, WINCH, IO, and
+	// the first real-time signal, 34.
+	f.write("42/status", "Name:\tnginx\nSigQ:\t1/31366\nSigPnd:\t0000000000004000\n"+
+		"ShdPnd:\t0000000000000000\nSigBlk:\t0000000000014000\nSigIgn:\t0000000000001000\n"+
+		"SigCgt:\t0000000218016a07\n")
+	f.write("43/status", "Name:\told\nSigPnd:\t0000000000000000\nSigBlk:\t0000000000000000\n")
+	f.write("44/status", "Name:\tbad\nSigPnd:\t0\nSigBlk:\t0\nSigIgn:\t0\nSigCgt:\tzz\n")
+
+	sigs, err := (&Process{Pid: 42}).Signals()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		mask SignalMask
+		exp  []string
+	}{
+		{"pending", sigs.Pending, []string{"SIGTERM"}},
+		{"blocked", sigs.Blocked, []string{"SIGTERM", "SIGCHLD"}},
+		{"ignored", sigs.Ignored, []string{"SIGPIPE"}},
+		{"caught", sigs.Caught, []string{"SIGHUP", "SIGINT", "SIGQUIT", "SIGUSR1", "SIGUSR2", "SIGALRM",
+			"SIGTERM", "SIGCHLD", "SIGWINCH", "SIGIO", "Signal(34)"}},
+	} {
+		if names := tc.mask.Names(); !reflect.DeepEqual(names, tc.exp) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.exp, names)
+		}
+	}
+	if names := SignalMask(0).Names(); names != nil {
+		t.Errorf("expected no names for an empty mask, got %v", names)
+	}
+
+	for _, pid := range []int32{43, 44} {
+		if _, err := (&Process{Pid: pid}).Signals(); err == nil {
+			t.Errorf("pid %d: expected an error", pid)
+		}
+	}
+	if _, err := (&Process{Pid: 45}).Signals(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestMemoryBytes(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("1/status", "Name:\tnginx\nVmSize:\t   90000 kB\nVmRSS:\t    5000 kB\nVmData:\t    2000 kB\nVmStk:\t     132 kB\nVmSwap:\t     128 kB\n")
+	f.write("2/status", "Name:\tidle\nVmSize:\t    4000 kB\nVmRSS:\t     100 kB\nVmData:\t     300 kB\nVmStk:\t       8 kB\nVmSwap:\t       0 kB\n")
+	// Kernel threads have no memory fields.
+	f.write("3/status", "Name:\tkthreadd\nState:\tS (sleeping)\n")
+	f.write("4/status", "Name:\tbroken\nVmSwap:\tlots\n")
+
+	cases := []struct {
+		pid                          int32
+		rss, swap, size, data, stack uint64
+	}{
+		{1, 5000 * 1024, 128 * 1024, 90000 * 1024, 2000 * 1024, 132 * 1024},
+		{2, 100 * 1024, 0, 4000 * 1024, 300 * 1024, 8 * 1024},
+	}
+	for _, tc := range cases {
+		p := &Process{Pid: tc.pid}
+		if rss, err := p.RssBytes(); err != nil || rss != tc.rss {
+			t.Errorf("pid %d: expected rss %d, got %d: %v", tc.pid, tc.rss, rss, err)
+		}
+		if swap, err := p.SwapBytes(); err != nil || swap != tc.swap {
+			t.Errorf("pid %d: expected swap %d, got %d: %v", tc.pid, tc.swap, swap, err)
+		}
+		if size, err := p.VmSize(); err != nil || size != tc.size {
+			t.Errorf("pid %d: expected size %d, got %d: %v", tc.pid, tc.size, size, err)
+		}
+		if data, err := p.VmData(); err != nil || data != tc.data {
+			t.Errorf("pid %d: expected data %d, got %d: %v", tc.pid, tc.data, data, err)
+		}
+		if stack, err := p.StackBytes(); err != nil || stack != tc.stack {
+			t.Errorf("pid %d: expected stack %d, got %d: %v", tc.pid, tc.stack, stack, err)
+		}
+	}
+	if _, err := (&Process{Pid: 3}).VmSize(); err == nil {
+		t.Errorf("expected an error for a kernel thread")
+	}
+	if _, err := (&Process{Pid: 3}).StackBytes(); err == nil {
+		t.Errorf("expected an error for a kernel thread")
+	}
+	for _, pid := range []int32{3, 4} {
+		if swap, err := (&Process{Pid: pid}).SwapBytes(); err == nil {
+			t.Errorf("pid %d: expected an error, got %d", pid, swap)
+		}
+	}
+}
+
+func TestUids(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("43/status", "Name:\tsetuid\nUid:\t1000\t0\t0\t0\n")
+	f.write("44/status", "Name:\tbroken\nUid:\t1000\n")
+
+	if r, e, s, err := (&Process{Pid: 42}).Uids(); err != nil || r != 1000 || e != 1000 || s != 1000 {
+		t.Errorf("expected all 1000, got %d %d %d: %v", r, e, s, err)
+	}
+	if r, e, s, err := (&Process{Pid: 43}).Uids(); err != nil || r != 1000 || e != 0 || s != 0 {
+		t.Errorf("expected 1000 0 0, got %d %d %d: %v", r, e, s, err)
+	}
+	if _, _, _, err := (&Process{Pid: 44}).Uids(); err == nil {
+		t.Errorf("expected an error for a malformed Uid")
+	}
+	if _, _, _, err := (&Process{Pid: 45}).Uids(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestUmask(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	f.write("43/status", "Name:\tprivate\nUmask:\t0077\n")
+	// Older kernels have no Umask field.
+	f.write("44/status", "Name:\told\nState:\tS (sleeping)\n")
+	f.write("45/status", "Name:\tbad\nUmask:\t0089\n")
+	f.write("46/status", "Name:\tbad\nUmask:\t01022\n")
+
+	for pid, exp := range map[int32]os.FileMode{42: 0022, 43: 0077} {
+		if mask, err := (&Process{Pid: pid}).Umask(); err != nil || mask != exp {
+			t.Errorf("pid %d: expected %#o, got %#o: %v", pid, exp, mask, err)
+		}
+	}
+	for _, pid := range []int32{44, 45, 46} {
+		if mask, err := (&Process{Pid: pid}).Umask(); err == nil {
+			t.Errorf("pid %d: expected an error, got %#o", pid, mask)
+		}
+	}
+	if _, err := (&Process{Pid: 47}).Umask(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestCoreDumping(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("1/status", "Name:\tserver\nCoreDumping:\t0\nTHP_enabled:\t1\n")
+	f.write("2/status", "Name:\tcrashed\nCoreDumping:\t1\nTHP_enabled:\t1\n")
+	// Older kernels have no CoreDumping field.
+	f.write("3/status", "Name:\told\nState:\tS (sleeping)\n")
+
+	for pid, exp := range map[int32]bool{1: false, 2: true, 3: false} {
+		dumping, err := (&Process{Pid: pid}).CoreDumping()
+		if err != nil {
+			t.Errorf("pid %d: unexpected error: %v", pid, err)
+			continue
+		}
+		if dumping != exp {
+			t.Errorf("pid %d: expected %v, got %v", pid, exp, dumping)
+		}
+	}
+
+	f.write("4/status", "Name:\tfuture\nCoreDumping:\tyes\n")
+	if _, err := (&Process{Pid: 4}).CoreDumping(); err == nil {
+		t.Errorf("expected an error for a malformed field")
+	}
+	if _, err := (&Process{Pid: 5}).CoreDumping(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NumThreads returns the number of threads in the process, from the Threads
+// field of /proc/<pid>/status.
+func (p *Process) NumThreads() (int32, error) {
+	val, err := p.statusField("Threads")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return 0, p.errorf("read status", "malformed Threads %q: %v", val, err)
+	}
+	return int32(n), nil
+}
+
+// NumThreadsViaTask returns the number of threads in the process by counting
+// the entries of /proc/<pid>/task.  This is authoritative, but costs a
+// directory read rather than a file read.  Each count is a snapshot, so while
+// the process is creating or exiting threads the two may briefly disagree.
+func (p *Process) NumThreadsViaTask() (int32, error) {
+	d, err := p.openFile("task")
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return 0, p.wrapErr("list threads", err)
+	}
+	return int32(len(names)), nil
+}
+
+// Threads returns the IDs of the process's threads, in increasing order, from
+// the entries of /proc/<pid>/task.  The first is the process itself.
+func (p *Process) Threads() ([]int32, error) {
+	d, err := p.openFile("task")
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	tids, err := readPids(context.Background(), d, -1)
+	if err != nil {
+		return nil, p.wrapErr("list threads", err)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	return tids, nil
+}
+
+// ThreadName returns the name of thread tid of the process, which a
+// program may set to describe what the thread does, e.g. "http-worker".  If
+// the thread has exited, the error matches ErrProcessNotFound.
+func (p *Process) ThreadName(tid int32) (string, error) {
+	data, err := p.readFile("task/" + strconv.Itoa(int(tid)) + "/comm")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// ThreadStates returns how many of the process's threads are in each state,
+// keyed by the single-letter state as Status returns it, from
+// /proc/<pid>/task/<tid>/stat.  The state of the process is that of its main
+// thread, so this finds, say, a worker thread stuck in uninterruptible sleep
+// (D) while the process looks fine.  Threads which exit while being read are
+// left out.
+func (p *Process) ThreadStates() (map[string]int, error) {
+	tids, err := p.Threads()
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]int{}
+	for _, tid := range tids {
+		name := "task/" + strconv.Itoa(int(tid)) + "/stat"
+		data, err := p.readFile(name)
+		if errors.Is(err, ErrProcessNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields, ok := parseStat(data)
+		if !ok || len(fields) == 0 {
+			return nil, p.errorf("read "+name, "malformed stat")
+		}
+		states[fields[0]]++
+	}
+	return states, nil
+}
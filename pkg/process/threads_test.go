@@ -0,0 +1,182 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// assertThreadCountsAgree fails the test if the two ways of counting threads
+// disagree, and returns the count.
+func assertThreadCountsAgree(t *testing.T, p *Process) int32 {
+	t.Helper()
+	fromStatus, err := p.NumThreads()
+	if err != nil {
+		t.Fatalf("pid %d: NumThreads: unexpected error: %v", p.Pid, err)
+	}
+	fromTask, err := p.NumThreadsViaTask()
+	if err != nil {
+		t.Fatalf("pid %d: NumThreadsViaTask: unexpected error: %v", p.Pid, err)
+	}
+	if fromStatus != fromTask {
+		t.Fatalf("pid %d: status says %d threads, task dir has %d", p.Pid, fromStatus, fromTask)
+	}
+	return fromTask
+}
+
+func TestNumThreads(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.write("42/status", "Name:\tnginx\nThreads:\t3\n")
+	for _, tid := range []int{42, 43, 44} {
+		f.write(fmt.Sprintf("42/task/%d/stat", tid), "")
+	}
+
+	if n := assertThreadCountsAgree(t, &Process{Pid: 42}); n != 3 {
+		t.Errorf("expected 3 threads, got %d", n)
+	}
+
+	if _, err := (&Process{Pid: 43}).NumThreads(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+	if _, err := (&Process{Pid: 43}).NumThreadsViaTask(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestThreadName(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "server"})
+	for tid, name := range map[int]string{42: "server", 44: "http-worker", 43: "gc worker"} {
+		f.write(fmt.Sprintf("42/task/%d/comm", tid), name+"\n")
+	}
+
+	p := &Process{Pid: 42}
+	tids, err := p.Threads()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []int32{42, 43, 44}; !reflect.DeepEqual(tids, exp) {
+		t.Fatalf("expected threads %v, got %v", exp, tids)
+	}
+	var names []string
+	for _, tid := range tids {
+		name, err := p.ThreadName(tid)
+		if err != nil {
+			t.Fatalf("tid %d: unexpected error: %v", tid, err)
+		}
+		names = append(names, name)
+	}
+	if exp := []string{"server", "gc worker", "http-worker"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("expected %v, got %v", exp, names)
+	}
+
+	if _, err := p.ThreadName(45); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound for an exited thread, got %v", err)
+	}
+	if _, err := (&Process{Pid: 43}).Threads(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestProcessesWithThreads(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "server"})
+	for tid, state := range map[int]string{10: "S", 11: "D", 13: "R"} {
+		f.write(fmt.Sprintf("10/task/%d/stat", tid), fmt.Sprintf("%d (server) %s 1 10 10 0 -1\n", tid, state))
+		f.write(fmt.Sprintf("10/task/%d/status", tid), fmt.Sprintf("Name:\tserver\nState:\t%s\nTgid:\t10\nPid:\t%d\nPPid:\t1\n", state, tid))
+	}
+	f.add(fakeProc{pid: 20, name: "single"})
+	f.write("20/task/20/stat", "20 (single) S 1 20 20 0 -1\n")
+	// Exited before its threads could be listed.
+	f.add(fakeProc{pid: 30, name: "gone"})
+	ctx := context.Background()
+
+	type entry struct{ pid, tgid int32 }
+	entries := func(opts ProcessesOptions) ([]entry, []*Process) {
+		t.Helper()
+		procs, err := ProcessesWithOptions(ctx, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []entry
+		for _, p := range procs {
+			got = append(got, entry{p.Pid, p.Tgid})
+		}
+		return got, procs
+	}
+
+	if got, _ := entries(ProcessesOptions{}); !reflect.DeepEqual(got, []entry{{10, 0}, {20, 0}, {30, 0}}) {
+		t.Errorf("expected only processes by default, got %v", got)
+	}
+	got, procs := entries(ProcessesOptions{IncludeThreads: true})
+	if exp := []entry{{10, 0}, {11, 10}, {13, 10}, {20, 0}, {30, 0}}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	// A thread is read from its process's task directory.
+	for i, exp := range map[int]string{1: "D", 2: "R"} {
+		if state, err := procs[i].Status(); err != nil || state != exp {
+			t.Errorf("tid %d: expected state %q, got %q: %v", procs[i].Pid, exp, state, err)
+		}
+		if name, err := procs[i].Name(); err != nil || name != "server" {
+			t.Errorf("tid %d: expected name %q, got %q: %v", procs[i].Pid, "server", name, err)
+		}
+		if running, err := procs[i].IsRunning(); err != nil || !running {
+			t.Errorf("tid %d: expected to be running: %v", procs[i].Pid, err)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ProcessesWithOptions(cancelled, ProcessesOptions{IncludeThreads: true}); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestThreadStates(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "server"})
+	for tid, state := range map[int]string{42: "S", 43: "D", 44: "S", 45: "R"} {
+		f.write(fmt.Sprintf("42/task/%d/stat", tid), fmt.Sprintf("%d (server) %s 1 42 42 0 -1\n", tid, state))
+	}
+	// Listed, but exited before its stat could be read.
+	f.write("42/task/46/comm", "exited\n")
+
+	states, err := (&Process{Pid: 42}).ThreadStates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := map[string]int{"S": 2, "D": 1, "R": 1}; !reflect.DeepEqual(states, exp) {
+		t.Errorf("expected %v, got %v", exp, states)
+	}
+
+	f.write("42/task/47/stat", "garbage")
+	if _, err := (&Process{Pid: 42}).ThreadStates(); err == nil {
+		t.Errorf("expected an error for a malformed stat")
+	}
+	if _, err := (&Process{Pid: 43}).ThreadStates(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
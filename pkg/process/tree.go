@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+)
+
+// maxAncestry bounds how far IsChildOf walks up the process tree, in case a
+// corrupt or racing proc filesystem produces a loop.
+const maxAncestry = 256
+
+// IsChildOf returns true if ancestorPid is the parent of the process, or the
+// parent of its parent, and so on.  A process is not its own child.
+func (p *Process) IsChildOf(ancestorPid int32) (bool, error) {
+	pid := p.Pid
+	for i := 0; i < maxAncestry; i++ {
+		if pid <= 1 {
+			// Reached init (or the kernel), without finding it.
+			return false, nil
+		}
+		ppid, err := p.fs.process(pid).Ppid()
+		if err != nil {
+			return false, err
+		}
+		if ppid == ancestorPid {
+			return true, nil
+		}
+		pid = ppid
+	}
+	return false, p.errorf("find ancestors", "more than %d generations deep", maxAncestry)
+}
+
+// Children returns the processes whose parent is the process, in increasing
+// PID order.  Every process is checked, since the kernel's own list of
+// children is per thread and not always available.  Processes which exit or
+// can't be read while they are checked are skipped.
+func (p *Process) Children() ([]*Process, error) {
+	return p.childrenMatching(func(*Process) (bool, error) { return true, nil })
+}
+
+// ChildrenByName returns the children of the process which are named name,
+// such as the workers of a server's master process, in increasing PID order.
+func (p *Process) ChildrenByName(name string) ([]*Process, error) {
+	return p.childrenMatching(func(child *Process) (bool, error) {
+		n, err := child.Name()
+		return n == name, err
+	})
+}
+
+// childrenMatching returns the children of the process for which match
+// returns true.
+func (p *Process) childrenMatching(match func(*Process) (bool, error)) ([]*Process, error) {
+	if _, err := p.fs.NewProcess(p.Pid); err != nil {
+		return nil, err
+	}
+	return p.fs.processesMatching(context.Background(), func(child *Process) (bool, error) {
+		ppid, err := child.Ppid()
+		if err != nil || ppid != p.Pid {
+			return false, err
+		}
+		return match(child)
+	})
+}
+
+// Pgid returns the ID of the process group the process belongs to, which is
+// what job control signals as a unit.
+func (p *Process) Pgid() (int32, error) {
+	pgid, err := p.statInt(5, "pgrp", 32)
+	return int32(pgid), err
+}
+
+// Sid returns the ID of the session the process belongs to.
+func (p *Process) Sid() (int32, error) {
+	sid, err := p.statInt(6, "session", 32)
+	return int32(sid), err
+}
+
+// IsSessionLeader returns true if the process leads its session, as a daemon
+// or login shell usually does.  Its session ID is then its own PID.
+func (p *Process) IsSessionLeader() (bool, error) {
+	sid, err := p.Sid()
+	return err == nil && sid == p.Pid, err
+}
+
+// IsGroupLeader returns true if the process leads its process group, in which
+// case signaling the group, rather than just the process, reaches everything
+// it started in that group.  Its process group ID is then its own PID.
+func (p *Process) IsGroupLeader() (bool, error) {
+	pgid, err := p.Pgid()
+	return err == nil && pgid == p.Pid, err
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// addTree adds init, a master (10) with two workers (20, 21), a grandchild
+// (30) of the master, and an unrelated process (40).
+func addTree(f *fixture) {
+	f.add(fakeProc{pid: 1, name: "init", ppid: 0})
+	f.add(fakeProc{pid: 10, name: "master", ppid: 1})
+	f.add(fakeProc{pid: 20, name: "worker", ppid: 10})
+	f.add(fakeProc{pid: 21, name: "worker", ppid: 10})
+	f.add(fakeProc{pid: 30, name: "helper", ppid: 20})
+	f.add(fakeProc{pid: 40, name: "worker", ppid: 1})
+}
+
+func TestIsChildOf(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	addTree(f)
+
+	cases := []struct {
+		pid      int32
+		ancestor int32
+		exp      bool
+	}{
+		{20, 10, true},
+		{30, 10, true},
+		{30, 20, true},
+		{30, 1, true},
+		{10, 20, false},
+		{40, 10, false},
+		{10, 10, false},
+		{1, 10, false},
+	}
+	for _, tc := range cases {
+		got, err := (&Process{Pid: tc.pid}).IsChildOf(tc.ancestor)
+		if err != nil {
+			t.Errorf("%d child of %d: unexpected error: %v", tc.pid, tc.ancestor, err)
+			continue
+		}
+		if got != tc.exp {
+			t.Errorf("%d child of %d: expected %v, got %v", tc.pid, tc.ancestor, tc.exp, got)
+		}
+	}
+}
+
+func TestIsChildOfLoop(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 50, name: "a", ppid: 51})
+	f.add(fakeProc{pid: 51, name: "b", ppid: 50})
+
+	if _, err := (&Process{Pid: 50}).IsChildOf(10); err == nil {
+		t.Errorf("expected an error for a parent loop")
+	}
+}
+
+func TestIsChildOfMissingParent(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 50, name: "orphan", ppid: 60})
+
+	if _, err := (&Process{Pid: 50}).IsChildOf(10); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	addTree(f)
+	f.add(fakeProc{pid: 22, name: "cache", ppid: 10})
+	// A child which can't be read is skipped.
+	f.write("23/stat", "garbage")
+	master := &Process{Pid: 10}
+
+	childPids := func(procs []*Process, err error) []int32 {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var pids []int32
+		for _, p := range procs {
+			pids = append(pids, p.Pid)
+		}
+		return pids
+	}
+	if pids := childPids(master.Children()); !reflect.DeepEqual(pids, []int32{20, 21, 22}) {
+		t.Errorf("expected children [20 21 22], got %v", pids)
+	}
+	if pids := childPids(master.ChildrenByName("worker")); !reflect.DeepEqual(pids, []int32{20, 21}) {
+		t.Errorf("expected workers [20 21], got %v", pids)
+	}
+	if pids := childPids(master.ChildrenByName("helper")); len(pids) != 0 {
+		t.Errorf("expected a grandchild not to be a child, got %v", pids)
+	}
+	if pids := childPids((&Process{Pid: 30}).Children()); len(pids) != 0 {
+		t.Errorf("expected no children, got %v", pids)
+	}
+
+	if _, err := (&Process{Pid: 50}).ChildrenByName("worker"); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestPgidSid(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx"})
+	// A comm with spaces and parens must not shift the fields.
+	f.write("43/stat", "43 (evil) 7 8 (x) S 1 40 30 0 -1 4194304\n")
+	f.write("44/stat", "44 (short) S 1\n")
+
+	cases := []struct {
+		pid, pgid, sid int32
+	}{
+		{42, 42, 42},
+		{43, 40, 30},
+	}
+	for _, tc := range cases {
+		p := &Process{Pid: tc.pid}
+		if pgid, err := p.Pgid(); err != nil || pgid != tc.pgid {
+			t.Errorf("pid %d: expected pgid %d, got %d: %v", tc.pid, tc.pgid, pgid, err)
+		}
+		if sid, err := p.Sid(); err != nil || sid != tc.sid {
+			t.Errorf("pid %d: expected sid %d, got %d: %v", tc.pid, tc.sid, sid, err)
+		}
+	}
+	if _, err := (&Process{Pid: 44}).Sid(); err == nil {
+		t.Errorf("expected an error for a short stat line")
+	}
+	if _, err := (&Process{Pid: 45}).Pgid(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestIsLeader(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	// Leads its group and session.
+	f.add(fakeProc{pid: 42, name: "sshd"})
+	// Leads a group within 42's session, like a shell job.
+	f.write("43/stat", "43 (job) S 42 43 42 0 -1 4194304\n")
+	// A member of 43's group.
+	f.write("44/stat", "44 (worker) S 43 43 42 0 -1 4194304\n")
+
+	cases := []struct {
+		pid                  int32
+		sessionLeader, group bool
+	}{
+		{42, true, true},
+		{43, false, true},
+		{44, false, false},
+	}
+	for _, tc := range cases {
+		p := &Process{Pid: tc.pid}
+		if leader, err := p.IsSessionLeader(); err != nil || leader != tc.sessionLeader {
+			t.Errorf("pid %d: expected session leader %v, got %v: %v", tc.pid, tc.sessionLeader, leader, err)
+		}
+		if leader, err := p.IsGroupLeader(); err != nil || leader != tc.group {
+			t.Errorf("pid %d: expected group leader %v, got %v: %v", tc.pid, tc.group, leader, err)
+		}
+	}
+	if _, err := (&Process{Pid: 45}).IsSessionLeader(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+	if _, err := (&Process{Pid: 45}).IsGroupLeader(); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
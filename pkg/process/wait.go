@@ -0,0 +1,400 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// DefaultPollInterval is how often the wait helpers re-read the proc
+// filesystem.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// WaitForExit blocks until the process exits or ctx is done.  A process whose
+// PID has been reused by a new process (that is, whose start time changed) is
+// considered to have exited.
+func (p *Process) WaitForExit(ctx context.Context, pollInterval time.Duration) error {
+	return p.waitForExit(ctx, clockOr(p.fs.clock), pollInterval, false, nil)
+}
+
+// errExpired is returned by waitForExit when expired fires.
+var errExpired = errors.New("expired")
+
+// waitForExit is WaitForExit, polling by c, which optionally also considers a
+// zombie to have exited, and gives up with errExpired once expired fires, if
+// it is not nil.
+func (p *Process) waitForExit(ctx context.Context, c Clock, pollInterval time.Duration, zombieExited bool, expired <-chan time.Time) error {
+	start, err := p.CreateTime()
+	if errors.Is(err, ErrProcessNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		if zombieExited {
+			if zombie, err := p.IsZombie(); err == nil && zombie {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-expired:
+			return errExpired
+		case <-c.After(pollInterval):
+		}
+		now, err := p.readCreateTime()
+		if errors.Is(err, ErrProcessNotFound) || (err == nil && now != start) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// TerminateGracefully sends SIGTERM to the process and waits up to grace for
+// it to exit, after which it sends SIGKILL and waits for that to take effect,
+// or for ctx to be done.
+//
+// A zombie has already exited, and only lingers until its parent reaps it, so
+// it is neither signaled nor waited for.  Reaping is the parent's
+// responsibility, not git-sync's.
+func (p *Process) TerminateGracefully(ctx context.Context, grace time.Duration) error {
+	if _, err := p.CreateTime(); errors.Is(err, ErrProcessNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if zombie, err := p.IsZombie(); errors.Is(err, ErrProcessNotFound) || (err == nil && zombie) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := sendSignal(p, ctx, syscall.SIGTERM); err != nil {
+		if isProcessGone(err) {
+			return nil
+		}
+		return err
+	}
+	c := clockOr(p.fs.clock)
+	if err := p.waitForExit(ctx, c, DefaultPollInterval, true, c.After(grace)); err != errExpired {
+		// Exited, given up on, or failed for some reason other than the
+		// grace period running out.
+		return err
+	}
+
+	if err := sendSignal(p, ctx, syscall.SIGKILL); err != nil {
+		if isProcessGone(err) {
+			return nil
+		}
+		return err
+	}
+	return p.waitForExit(ctx, c, DefaultPollInterval, true, nil)
+}
+
+// TerminateGroupGracefully is TerminateGracefully for the whole process group
+// of the process pid, normally its leader, as for a server which forks
+// workers into its group.  SIGTERM goes to the group, and once pid has
+// exited, or grace has passed, SIGKILL goes to the group too, for any workers
+// which outlived it.  It returns once pid has exited, or ctx is done.  The
+// caller's own process group is never signaled.
+func TerminateGroupGracefully(ctx context.Context, pid int32, grace time.Duration) error {
+	return ProcFS{}.TerminateGroupGracefully(ctx, pid, grace)
+}
+
+// TerminateGroupGracefully is the package-level TerminateGroupGracefully,
+// for the process pid in this proc filesystem, waiting by its clock.
+func (fs ProcFS) TerminateGroupGracefully(ctx context.Context, pid int32, grace time.Duration) error {
+	p, err := fs.NewProcess(pid)
+	if errors.Is(err, ErrProcessNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := p.CreateTime(); errors.Is(err, ErrProcessNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pgid, err := p.Pgid()
+	if errors.Is(err, ErrProcessNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if pgid <= 0 || int(pgid) == syscall.Getpgrp() {
+		return fmt.Errorf("refusing to signal process group %d of pid %d", pgid, pid)
+	}
+
+	if err := signalGroup(ctx, pgid, syscall.SIGTERM); err != nil && !isProcessGone(err) {
+		return err
+	}
+	c := clockOr(fs.clock)
+	if err := p.waitForExit(ctx, c, DefaultPollInterval, true, c.After(grace)); err != nil && err != errExpired {
+		return err
+	}
+	if err := signalGroup(ctx, pgid, syscall.SIGKILL); err != nil && !isProcessGone(err) {
+		return err
+	}
+	return p.waitForExit(ctx, c, DefaultPollInterval, true, nil)
+}
+
+// signalGroup sends sig to every process in the process group pgid, unless
+// ctx is already done.  It goes through sendSignal like any other signal,
+// naming the group by its negated ID.
+func signalGroup(ctx context.Context, pgid int32, sig syscall.Signal) error {
+	return sendSignal(&Process{Pid: -pgid}, ctx, sig)
+}
+
+// Replaced returns true if the process has exited, or its PID now belongs to
+// a process which started later, since its start time was first read.  A
+// process whose start time has never been read is compared with itself, so
+// call CreateTime before whatever might replace it.
+func (p *Process) Replaced() (bool, error) {
+	start, err := p.CreateTime()
+	if errors.Is(err, ErrProcessNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now, err := p.readCreateTime()
+	if errors.Is(err, ErrProcessNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return now != start, nil
+}
+
+// SignalAndDetectRestart sends sig to the process, waits for wait, and then
+// reports whether the process was replaced, as by Replaced, rather than, say,
+// reloading its config in place.
+func (p *Process) SignalAndDetectRestart(ctx context.Context, sig Signal, wait time.Duration) (bool, error) {
+	if err := sig.valid(); err != nil {
+		return false, err
+	}
+	if _, err := p.CreateTime(); err != nil {
+		return false, err
+	}
+	if err := sendSignal(p, ctx, syscall.Signal(sig)); err != nil && !isProcessGone(err) {
+		return false, err
+	}
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-clockOr(p.fs.clock).After(wait):
+	}
+	return p.Replaced()
+}
+
+// WaitForExeChange blocks until the process is running a different
+// executable than when it was called, as after a reload which exec's a new
+// binary in place, and returns the new one, as from Exe.  Such a process
+// keeps its PID and start time, so Replaced can't tell.  The executable
+// being deleted or replaced on disk, which the kernel reports by appending
+// " (deleted)", doesn't count, since the process is still running the old
+// one.  If the process exits, or its PID is reused, first, the error wraps
+// ErrProcessNotFound.
+func (p *Process) WaitForExeChange(ctx context.Context, pollInterval time.Duration) (string, error) {
+	start, err := p.CreateTime()
+	if err != nil {
+		return "", err
+	}
+	orig, err := p.Exe()
+	if err != nil {
+		return "", err
+	}
+	c := clockOr(p.fs.clock)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-c.After(pollInterval):
+		}
+		exe, err := p.Exe()
+		if err != nil {
+			return "", err
+		}
+		if now, err := p.readCreateTime(); err != nil {
+			return "", err
+		} else if now != start {
+			return "", p.wrapErr("read exe", ErrProcessNotFound)
+		}
+		if exe != orig && exe != orig+deletedSuffix {
+			return exe, nil
+		}
+	}
+}
+
+// ErrNotChild is returned by SignalAndWait for a process which is not a child
+// of the caller, and so can't be waited for.
+var ErrNotChild = errors.New("not a child of this process")
+
+// SignalAndWait sends sig to pid, which must be a child of the caller, waits
+// for it to exit, and returns its exit code.  A child killed by a signal is
+// given the code a shell would, 128 plus the signal number.  The child is
+// reaped, so nothing else, such as exec.Cmd.Wait, may wait for it.  A child
+// which has already exited isn't signaled, but its exit code is returned.
+func SignalAndWait(pid int32, sig Signal) (int, error) {
+	if err := sig.valid(); err != nil {
+		return 0, err
+	}
+	// Checking first makes sure only children are signaled.
+	if ws, exited, err := wait4(pid, syscall.WNOHANG); err != nil {
+		return 0, err
+	} else if exited {
+		return exitCode(ws), nil
+	}
+	if err := kill(pid, syscall.Signal(sig)); err != nil && !isProcessGone(err) {
+		return 0, err
+	}
+	ws, _, err := wait4(pid, 0)
+	if err != nil {
+		return 0, err
+	}
+	return exitCode(ws), nil
+}
+
+// wait4 waits for the child pid to change state, as wait4(2) does, and
+// reports whether it exited.  A pid which isn't a child is ErrNotChild.
+func wait4(pid int32, options int) (syscall.WaitStatus, bool, error) {
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(int(pid), &ws, options, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.ECHILD {
+			return 0, false, fmt.Errorf("can't wait for pid %d: %w", pid, ErrNotChild)
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("can't wait for pid %d: %w", pid, err)
+		}
+		if wpid == 0 {
+			// Still running, with WNOHANG.
+			return 0, false, nil
+		}
+		if ws.Exited() || ws.Signaled() {
+			return ws, true, nil
+		}
+		// Stopped or continued, which only WUNTRACED or WCONTINUED report.
+	}
+}
+
+// exitCode returns the exit code of a child which exited with status ws.
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// WaitForProcess blocks until a process named name exists or ctx is done,
+// and returns that process.
+func WaitForProcess(ctx context.Context, name string, pollInterval time.Duration) (*Process, error) {
+	return waitForProcess(ctx, name, pollInterval, func(*Process) bool { return true })
+}
+
+// waitForProcess blocks until a process named name, for which accept returns
+// true, exists or ctx is done.
+func waitForProcess(ctx context.Context, name string, pollInterval time.Duration, accept func(*Process) bool) (*Process, error) {
+	for {
+		procs, err := processesByName(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range procs {
+			if accept(p) {
+				return p, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-defaultClock.After(pollInterval):
+		}
+	}
+}
+
+// identity is what distinguishes a process from a later one which reuses its
+// PID.
+type identity struct {
+	pid        int32
+	createTime int64
+}
+
+// RestartByName sends sig to every process named name, waits for them all
+// to exit, and then waits for a new process named name to start, which it
+// returns.  This is intended for a supervisor which restarts a server when it
+// exits.  Processes with the same PID and start time as one which was
+// signaled are never mistaken for the new process.  The whole operation must
+// complete within timeout.
+func RestartByName(ctx context.Context, name string, sig Signal, timeout time.Duration) (*Process, error) {
+	if err := sig.valid(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	procs, err := processesByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	old := map[identity]bool{}
+	var targets []*Process
+	for _, p := range procs {
+		start, err := p.CreateTime()
+		if err != nil {
+			// Already gone, or not readable.
+			continue
+		}
+		old[identity{p.Pid, start}] = true
+		targets = append(targets, p)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no process named %q: %w", name, ErrProcessNotFound)
+	}
+
+	if _, err := signalAll(ctx, targets, syscall.Signal(sig)); err != nil {
+		return nil, err
+	}
+	for _, p := range targets {
+		if err := p.WaitForExit(ctx, DefaultPollInterval); err != nil {
+			return nil, fmt.Errorf("waiting for pid %d to exit: %w", p.Pid, err)
+		}
+	}
+
+	p, err := waitForProcess(ctx, name, DefaultPollInterval, func(p *Process) bool {
+		start, err := p.CreateTime()
+		return err == nil && !old[identity{p.Pid, start}]
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for new process named %q: %w", name, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,509 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitForExit(t *testing.T) {
+	cmd := startNamed(t, uniqueName("exit"))
+	defer stopNamed(cmd)
+	p := &Process{Pid: int32(cmd.Process.Pid)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.WaitForExit(ctx, 10*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("expected a timeout while running, got %v", err)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.WaitForExit(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitForExeChange(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "server", start: 100})
+	clock := newFakeClock()
+	fs := ProcFS{}.WithClock(clock)
+	link := filepath.Join(f.root, "42", "exe")
+	setExe := func(target string) {
+		t.Helper()
+		os.Remove(link)
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+	setExe("/srv/blue/server")
+
+	type result struct {
+		exe string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		exe, err := fs.process(42).WaitForExeChange(context.Background(), time.Second)
+		done <- result{exe, err}
+	}()
+
+	// Neither nothing changing nor the old binary being deleted counts.
+	for _, exe := range []string{"/srv/blue/server", "/srv/blue/server (deleted)"} {
+		clock.BlockUntil(1)
+		setExe(exe)
+		clock.Advance(time.Second)
+	}
+	clock.BlockUntil(1)
+	select {
+	case r := <-done:
+		t.Fatalf("returned before the exe changed: %+v", r)
+	default:
+	}
+
+	setExe("/srv/green/server")
+	clock.Advance(time.Second)
+	r := <-done
+	if r.err != nil || r.exe != "/srv/green/server" {
+		t.Errorf("expected /srv/green/server, got %q: %v", r.exe, r.err)
+	}
+
+	// A process which exits never changes.
+	go func() {
+		exe, err := fs.process(42).WaitForExeChange(context.Background(), time.Second)
+		done <- result{exe, err}
+	}()
+	clock.BlockUntil(1)
+	os.RemoveAll(filepath.Join(f.root, "42"))
+	clock.Advance(time.Second)
+	if r := <-done; !errors.Is(r.err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %q: %v", r.exe, r.err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.add(fakeProc{pid: 43, name: "server", start: 100})
+	if err := os.Symlink("/srv/blue/server", filepath.Join(f.root, "43", "exe")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if _, err := fs.process(43).WaitForExeChange(ctx, time.Second); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWaitForProcess(t *testing.T) {
+	name := uniqueName("wfp")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	started := make(chan *exec.Cmd, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		started <- startNamed(t, name)
+	}()
+	p, err := WaitForProcess(ctx, name, 10*time.Millisecond)
+	cmd := <-started
+	defer stopNamed(cmd)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Pid != int32(cmd.Process.Pid) {
+		t.Errorf("expected pid %d, got %d", cmd.Process.Pid, p.Pid)
+	}
+}
+
+func TestRestartByName(t *testing.T) {
+	name := uniqueName("rst")
+	first := startNamed(t, name)
+	defer stopNamed(first)
+
+	// Act as a supervisor, which restarts the process when it exits.
+	restarted := make(chan *exec.Cmd, 1)
+	go func() {
+		first.Wait()
+		restarted <- startNamed(t, name)
+	}()
+
+	p, err := RestartByName(context.Background(), name, Signal(syscall.SIGTERM), 10*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second := <-restarted
+	defer stopNamed(second)
+	if p.Pid != int32(second.Process.Pid) {
+		t.Errorf("expected new pid %d, got %d (old pid %d)", second.Process.Pid, p.Pid, first.Process.Pid)
+	}
+	if ws := first.ProcessState.Sys().(syscall.WaitStatus); !ws.Signaled() || ws.Signal() != syscall.SIGTERM {
+		t.Errorf("expected old process to get SIGTERM, got %v", first.ProcessState)
+	}
+}
+
+func TestRestartByNameNoProcess(t *testing.T) {
+	_, err := RestartByName(context.Background(), uniqueName("none"), Signal(syscall.SIGTERM), time.Second)
+	if !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestRestartByNameTimeout(t *testing.T) {
+	name := uniqueName("rto")
+	first := startNamed(t, name)
+	// Reap the process as soon as it exits, so that it isn't left a
+	// zombie, which RestartByName would wait for.  Cleanup only kills it,
+	// since this goroutine does the waiting.
+	exited := make(chan struct{})
+	go func() {
+		first.Wait()
+		close(exited)
+	}()
+	defer func() {
+		first.Process.Kill()
+		<-exited
+		os.RemoveAll(filepath.Dir(first.Path))
+	}()
+
+	// Nothing restarts the process, so this must time out.
+	_, err := RestartByName(context.Background(), name, Signal(syscall.SIGTERM), 500*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestTerminateGracefullyZombie(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "nginx", state: "Z", start: 100})
+	rec := recordSignals(t)
+	defer rec.restore()
+	p := &Process{Pid: 42}
+
+	if zombie, err := p.IsZombie(); err != nil || !zombie {
+		t.Fatalf("expected a zombie: %v", err)
+	}
+	start := time.Now()
+	if err := p.TerminateGracefully(context.Background(), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected an immediate return, took %v", elapsed)
+	}
+	if pids := rec.signaled(); len(pids) != 0 {
+		t.Errorf("expected no signals, got %v", pids)
+	}
+}
+
+func TestTerminateGracefully(t *testing.T) {
+	cmd := startNamed(t, uniqueName("term"))
+	defer stopNamed(cmd)
+	p := &Process{Pid: int32(cmd.Process.Pid)}
+
+	// The child stays a zombie until cmd.Wait reaps it, which shouldn't hold
+	// up TerminateGracefully.
+	start := time.Now()
+	if err := p.TerminateGracefully(context.Background(), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("expected a prompt return, took %v", elapsed)
+	}
+	if sig := exitSignal(t, cmd); sig != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM, got %v", sig)
+	}
+}
+
+func TestTerminateGracefullyGraceWindow(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 42, name: "stubborn", start: 100})
+	rec := recordSignals(t)
+	defer rec.restore()
+	clock := newFakeClock()
+	p := ProcFS{}.WithClock(clock).process(42)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.TerminateGracefully(context.Background(), 10*time.Second)
+	}()
+
+	// Waiting for the grace period and the first poll.
+	clock.BlockUntil(2)
+	if exp := []syscall.Signal{syscall.SIGTERM}; !reflect.DeepEqual(rec.sent(), exp) {
+		t.Fatalf("expected %v, got %v", exp, rec.sent())
+	}
+	clock.Advance(9 * time.Second)
+	clock.BlockUntil(2)
+	if exp := []syscall.Signal{syscall.SIGTERM}; !reflect.DeepEqual(rec.sent(), exp) {
+		t.Fatalf("expected no SIGKILL within the grace period, got %v", rec.sent())
+	}
+
+	clock.Advance(time.Second)
+	exp := []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}
+	for deadline := time.Now().Add(5 * time.Second); !reflect.DeepEqual(rec.sent(), exp); {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v once the grace period ended, got %v", exp, rec.sent())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The kill takes effect, which the next poll notices.
+	if err := os.RemoveAll(filepath.Join(f.root, "42")); err != nil {
+		t.Fatalf("failed to remove process: %v", err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(DefaultPollInterval)
+		case <-deadline:
+			t.Fatalf("TerminateGracefully did not return")
+		}
+	}
+}
+
+func TestSignalAndWait(t *testing.T) {
+	// Killed by the signal.
+	cmd := startNamed(t, uniqueName("saw"))
+	defer stopNamed(cmd)
+	if code, err := SignalAndWait(int32(cmd.Process.Pid), Signal(syscall.SIGTERM)); err != nil || code != 128+int(syscall.SIGTERM) {
+		t.Errorf("expected exit code %d, got %d: %v", 128+int(syscall.SIGTERM), code, err)
+	}
+
+	// Exiting with a code of its own on the signal.
+	cmd = exec.Command("sh", "-c", `trap "exit 3" TERM; echo ready; while :; do sleep 0.01; done`)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sh: %v", err)
+	}
+	var ready string
+	if _, err := fmt.Fscan(out, &ready); err != nil {
+		t.Fatalf("child never got ready: %v", err)
+	}
+	if code, err := SignalAndWait(int32(cmd.Process.Pid), Signal(syscall.SIGTERM)); err != nil || code != 3 {
+		t.Errorf("expected exit code 3, got %d: %v", code, err)
+	}
+
+	// Already exited, so there's nothing to signal.
+	cmd = exec.Command("sh", "-c", "exit 7")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sh: %v", err)
+	}
+	for end := time.Now().Add(5 * time.Second); !gone(int32(cmd.Process.Pid)); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatalf("child never exited")
+		}
+	}
+	if code, err := SignalAndWait(int32(cmd.Process.Pid), Signal(syscall.SIGTERM)); err != nil || code != 7 {
+		t.Errorf("expected exit code 7, got %d: %v", code, err)
+	}
+
+	// Not ours to wait for, so it mustn't be signaled either.
+	if _, err := SignalAndWait(1, Signal(syscall.SIGTERM)); !errors.Is(err, ErrNotChild) {
+		t.Errorf("expected ErrNotChild, got %v", err)
+	}
+	if _, err := SignalAndWait(int32(cmd.Process.Pid), Signal(0)); err == nil {
+		t.Errorf("expected an error for an invalid signal")
+	}
+}
+
+// startGroup starts a shell leading a new process group, with a worker forked
+// into the group, and returns the shell and the worker's PID.  If stubborn
+// is set, both ignore SIGTERM.
+func startGroup(t *testing.T, stubborn bool) (*exec.Cmd, int32) {
+	t.Helper()
+	script := "sleep 1000 & echo $!; wait"
+	if stubborn {
+		script = `trap "" TERM; ` + script
+	}
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sh: %v", err)
+	}
+	var worker int32
+	if _, err := fmt.Fscan(out, &worker); err != nil {
+		stopGroup(cmd)
+		t.Fatalf("failed to read the worker's pid: %v", err)
+	}
+	return cmd, worker
+}
+
+// stopGroup kills the process group led by cmd, started by startGroup.
+func stopGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	cmd.Wait()
+}
+
+// gone returns true if pid has exited, including if it is a zombie.
+func gone(pid int32) bool {
+	p := &Process{Pid: pid}
+	zombie, err := p.IsZombie()
+	return errors.Is(err, ErrProcessNotFound) || (err == nil && zombie)
+}
+
+func TestTerminateGroupGracefully(t *testing.T) {
+	for _, stubborn := range []bool{false, true} {
+		leader, worker := startGroup(t, stubborn)
+		defer stopGroup(leader)
+		pid := int32(leader.Process.Pid)
+		clock := newFakeClock()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- ProcFS{}.WithClock(clock).TerminateGroupGracefully(context.Background(), pid, 10*time.Second)
+		}()
+		if stubborn {
+			// Waiting for the grace period and the first poll.
+			clock.BlockUntil(2)
+			time.Sleep(50 * time.Millisecond)
+			if gone(pid) || gone(worker) {
+				t.Fatalf("expected processes ignoring SIGTERM to survive it")
+			}
+			clock.Advance(10 * time.Second)
+		}
+
+		deadline := time.After(10 * time.Second)
+	wait:
+		for {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("stubborn=%v: unexpected error: %v", stubborn, err)
+				}
+				break wait
+			case <-time.After(time.Millisecond):
+				clock.Advance(DefaultPollInterval)
+			case <-deadline:
+				t.Fatalf("stubborn=%v: TerminateGroupGracefully did not return", stubborn)
+			}
+		}
+		exp := syscall.SIGTERM
+		if stubborn {
+			exp = syscall.SIGKILL
+		}
+		if sig := exitSignal(t, leader); sig != exp {
+			t.Errorf("stubborn=%v: expected the leader to get %v, got %v", stubborn, exp, sig)
+		}
+		for end := time.Now().Add(5 * time.Second); !gone(worker); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(end) {
+				t.Fatalf("stubborn=%v: expected worker %d to be killed with the group", stubborn, worker)
+			}
+		}
+	}
+}
+
+func TestTerminateGroupGracefullyOwnGroup(t *testing.T) {
+	if err := TerminateGroupGracefully(context.Background(), int32(os.Getpid()), time.Second); err == nil {
+		t.Fatalf("expected an error for our own process group")
+	}
+	if err := TerminateGroupGracefully(context.Background(), 1<<30, time.Second); err != nil {
+		t.Errorf("expected no error for a missing process, got %v", err)
+	}
+}
+
+func TestTerminateGroupGracefullySignalsGroup(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 31337, name: "server", state: "Z"})
+	rec := recordSignals(t)
+	defer rec.restore()
+
+	if err := TerminateGroupGracefully(context.Background(), 31337, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, exp := rec.signaled(), []int32{-31337, -31337}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected the group to be signaled as %v, got %v", exp, got)
+	}
+	if got, exp := rec.sent(), []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestSignalAndDetectRestart(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		restart   func(t *testing.T, f *fixture)
+		restarted bool
+	}{
+		{"reload in place", func(t *testing.T, f *fixture) {}, false},
+		{"restart with same pid", func(t *testing.T, f *fixture) {
+			f.add(fakeProc{pid: 42, name: "server", start: 500})
+		}, true},
+		{"exit", func(t *testing.T, f *fixture) {
+			if err := os.RemoveAll(filepath.Join(f.root, "42")); err != nil {
+				t.Fatalf("failed to remove process: %v", err)
+			}
+		}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			defer f.cleanup()
+			f.add(fakeProc{pid: 42, name: "server", start: 100})
+			rec := recordSignals(t)
+			defer rec.restore()
+			clock := newFakeClock()
+
+			type result struct {
+				restarted bool
+				err       error
+			}
+			done := make(chan result, 1)
+			go func() {
+				restarted, err := ProcFS{}.WithClock(clock).process(42).SignalAndDetectRestart(context.Background(), Signal(syscall.SIGHUP), time.Second)
+				done <- result{restarted, err}
+			}()
+			clock.BlockUntil(1)
+			if exp := []syscall.Signal{syscall.SIGHUP}; !reflect.DeepEqual(rec.sent(), exp) {
+				t.Fatalf("expected %v, got %v", exp, rec.sent())
+			}
+			tc.restart(t, f)
+			clock.Advance(time.Second)
+
+			r := <-done
+			if r.err != nil {
+				t.Fatalf("unexpected error: %v", r.err)
+			}
+			if r.restarted != tc.restarted {
+				t.Errorf("expected restarted=%v, got %v", tc.restarted, r.restarted)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch re-reads the proc filesystem.
+const DefaultWatchInterval = time.Second
+
+// ProcessEventType says what happened to a watched process.
+type ProcessEventType int
+
+const (
+	// ProcessStarted means a process with a watched name appeared.
+	ProcessStarted ProcessEventType = iota
+	// ProcessExited means a process with a watched name went away.
+	ProcessExited
+)
+
+func (t ProcessEventType) String() string {
+	if t == ProcessStarted {
+		return "started"
+	}
+	return "exited"
+}
+
+// ProcessEvent is sent by Watch when a watched process starts or exits.
+type ProcessEvent struct {
+	Type    ProcessEventType
+	Name    string
+	Process *Process
+}
+
+// Watch reports processes with any of the given names starting and exiting,
+// until ctx is done, at which point the returned channel is closed.
+// Processes which already exist when Watch is called are not reported as
+// started.  A PID which is reused by a new process with the same name is
+// reported as an exit followed by a start.  The changes seen by each poll
+// are reported in PID order.  Use NewWatcher instead to be able
+// to wait for the watch to stop.
+func Watch(ctx context.Context, names []string) (<-chan ProcessEvent, error) {
+	w, err := ProcFS{}.newWatcher(ctx, names, DefaultWatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	return w.Events(), nil
+}
+
+// Watcher reports processes starting and exiting, like Watch, until it is
+// stopped.
+type Watcher struct {
+	events <-chan ProcessEvent
+	cancel context.CancelFunc
+	// done is closed when the polling goroutine has exited.
+	done chan struct{}
+}
+
+// NewWatcher starts watching for processes with any of the given names, as
+// Watch does, until ctx is done or Stop is called.
+func NewWatcher(ctx context.Context, names []string) (*Watcher, error) {
+	return ProcFS{}.NewWatcher(ctx, names)
+}
+
+// NewWatcher is the package-level NewWatcher, for the processes in this proc
+// filesystem, polling by its clock.
+func (fs ProcFS) NewWatcher(ctx context.Context, names []string) (*Watcher, error) {
+	return fs.newWatcher(ctx, names, DefaultWatchInterval)
+}
+
+func (fs ProcFS) newWatcher(ctx context.Context, names []string, interval time.Duration) (*Watcher, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	known, err := fs.scanNamed(ctx, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	c := clockOr(fs.clock)
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan ProcessEvent)
+	w := &Watcher{events: ch, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.After(interval):
+			}
+			current, err := fs.scanNamed(ctx, wanted)
+			if err != nil {
+				// Try again next time.
+				continue
+			}
+			var events []ProcessEvent
+			for id, ev := range known {
+				if _, ok := current[id]; !ok {
+					events = append(events, ProcessEvent{Type: ProcessExited, Name: ev.Name, Process: ev.Process})
+				}
+			}
+			for id, ev := range current {
+				if _, ok := known[id]; !ok {
+					events = append(events, ProcessEvent{Type: ProcessStarted, Name: ev.Name, Process: ev.Process})
+				}
+			}
+			// Stable, so that a reused PID's exit stays before its start.
+			sort.SliceStable(events, func(i, j int) bool {
+				return events[i].Process.Pid < events[j].Process.Pid
+			})
+			for _, ev := range events {
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			known = current
+		}
+	}()
+	return w, nil
+}
+
+// Events returns the channel on which events are sent.  It is closed once
+// the watcher has stopped.
+func (w *Watcher) Events() <-chan ProcessEvent {
+	return w.events
+}
+
+// Stop halts polling, and returns once the background goroutine has exited
+// and Events has been closed.  Events which haven't been received are
+// dropped.  It is safe to call Stop more than once, including concurrently.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// scanNamed returns every process in this proc filesystem whose name is in
+// wanted, keyed by identity.
+func (fs ProcFS) scanNamed(ctx context.Context, wanted map[string]bool) (map[identity]ProcessEvent, error) {
+	procs, err := fs.Processes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := map[identity]ProcessEvent{}
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil {
+			countSkip(err)
+			continue
+		}
+		if !wanted[name] {
+			continue
+		}
+		start, err := p.CreateTime()
+		if err != nil {
+			countSkip(err)
+			continue
+		}
+		found[identity{p.Pid, start}] = ProcessEvent{Name: name, Process: p}
+	}
+	return found, nil
+}
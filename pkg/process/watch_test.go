@@ -0,0 +1,163 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event from ch, failing the test if there is
+// none within a few seconds.
+func nextEvent(t *testing.T, ch <-chan ProcessEvent) ProcessEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatalf("channel closed unexpectedly")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for an event")
+	}
+	return ProcessEvent{}
+}
+
+func expectEvent(t *testing.T, ev ProcessEvent, typ ProcessEventType, pid int32, name string) {
+	t.Helper()
+	if ev.Type != typ || ev.Process.Pid != pid || ev.Name != name {
+		t.Errorf("expected %v event for pid %d (%s), got %v for pid %d (%s)", typ, pid, name, ev.Type, ev.Process.Pid, ev.Name)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx", start: 100})
+	f.add(fakeProc{pid: 11, name: "other", start: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := ProcFS{}.newWatcher(ctx, []string{"nginx", "php-fpm"}, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ch := w.Events()
+
+	// Unwatched names are ignored.
+	f.add(fakeProc{pid: 12, name: "other", start: 200})
+	f.add(fakeProc{pid: 13, name: "php-fpm", start: 200})
+	expectEvent(t, nextEvent(t, ch), ProcessStarted, 13, "php-fpm")
+
+	if err := os.RemoveAll(filepath.Join(f.root, "10")); err != nil {
+		t.Fatalf("failed to remove process: %v", err)
+	}
+	expectEvent(t, nextEvent(t, ch), ProcessExited, 10, "nginx")
+
+	// Reusing a PID is an exit and a start.
+	f.add(fakeProc{pid: 13, name: "php-fpm", start: 300})
+	first, second := nextEvent(t, ch), nextEvent(t, ch)
+	expectEvent(t, first, ProcessExited, 13, "php-fpm")
+	expectEvent(t, second, ProcessStarted, 13, "php-fpm")
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("expected no more events after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("channel was not closed after cancel")
+	}
+}
+
+func TestWatchOrder(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx", start: 100})
+	f.add(fakeProc{pid: 30, name: "nginx", start: 100})
+	f.add(fakeProc{pid: 40, name: "nginx", start: 100})
+	clock := newFakeClock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := ProcFS{}.WithClock(clock).newWatcher(ctx, []string{"nginx"}, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// All of these are seen by the same poll.
+	for _, pid := range []string{"10", "30"} {
+		if err := os.RemoveAll(filepath.Join(f.root, pid)); err != nil {
+			t.Fatalf("failed to remove process: %v", err)
+		}
+	}
+	f.add(fakeProc{pid: 50, name: "nginx", start: 200})
+	f.add(fakeProc{pid: 20, name: "nginx", start: 200})
+	f.add(fakeProc{pid: 40, name: "nginx", start: 200})
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+
+	ch := w.Events()
+	expectEvent(t, nextEvent(t, ch), ProcessExited, 10, "nginx")
+	expectEvent(t, nextEvent(t, ch), ProcessStarted, 20, "nginx")
+	expectEvent(t, nextEvent(t, ch), ProcessExited, 30, "nginx")
+	expectEvent(t, nextEvent(t, ch), ProcessExited, 40, "nginx")
+	expectEvent(t, nextEvent(t, ch), ProcessStarted, 40, "nginx")
+	expectEvent(t, nextEvent(t, ch), ProcessStarted, 50, "nginx")
+}
+
+func TestWatcherStop(t *testing.T) {
+	f := newFixture(t)
+	defer f.cleanup()
+	f.add(fakeProc{pid: 10, name: "nginx", start: 100})
+
+	w, err := ProcFS{}.newWatcher(context.Background(), []string{"nginx"}, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.add(fakeProc{pid: 11, name: "nginx", start: 200})
+	expectEvent(t, nextEvent(t, w.Events()), ProcessStarted, 11, "nginx")
+
+	// Leave an event pending, which mustn't keep the goroutine alive.
+	f.add(fakeProc{pid: 12, name: "nginx", start: 300})
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		w.Stop()
+		close(stopped)
+	}()
+	w.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop did not return")
+	}
+	select {
+	case <-w.done:
+	default:
+		t.Errorf("expected the goroutine to have exited")
+	}
+	for range w.Events() {
+		// Drain anything sent before the stop; the channel must be closed.
+	}
+}